@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// AutoscalePolicy configures how a step adds and removes workers based on
+// how many items are queued for it and how busy its workers are.
+type AutoscalePolicy struct {
+	// MinWorkers is the fewest workers to keep.
+	// Defaults to 1
+	MinWorkers int
+	// MaxWorkers is the most workers to add, never more than MaxWorkerCount.
+	// Defaults to MaxWorkerCount
+	MaxWorkers int
+	// Interval is how often the queue and workers are checked.
+	// Defaults to 100ms
+	Interval time.Duration
+	// Cooldown is the least time between scaling events.
+	// Defaults to Interval
+	Cooldown time.Duration
+	// Backlog is the number of queued items, out of MaxBufferSize, that adds
+	// a worker.
+	// Defaults to half of MaxBufferSize
+	Backlog int
+	// Utilization is the fraction of busy workers below which a worker is
+	// removed while nothing is queued. Only map, filter and flat map steps
+	// know when their workers are busy, other steps count their workers as
+	// busy while items are queued.
+	// Defaults to 0.5
+	Utilization float64
+}
+
+// bounds returns the minimum and maximum number of workers.
+func (p *AutoscalePolicy) bounds() (int, int) {
+	min, max := p.MinWorkers, p.MaxWorkers
+	if max <= 0 || max > MaxWorkerCount {
+		max = MaxWorkerCount
+	}
+	if min < 1 {
+		min = 1
+	}
+	if min > max {
+		min = max
+	}
+	return min, max
+}
+
+func (p *AutoscalePolicy) interval() time.Duration {
+	if p.Interval <= 0 {
+		return 100 * time.Millisecond
+	}
+	return p.Interval
+}
+
+func (p *AutoscalePolicy) cooldown() time.Duration {
+	if p.Cooldown <= 0 {
+		return p.interval()
+	}
+	return p.Cooldown
+}
+
+func (p *AutoscalePolicy) backlog() int {
+	if p.Backlog <= 0 {
+		return MaxBufferSize / 2
+	}
+	return p.Backlog
+}
+
+func (p *AutoscalePolicy) utilization() float64 {
+	if p.Utilization <= 0 {
+		return 0.5
+	}
+	return p.Utilization
+}
+
+// Workers returns the number of workers the step is running.
+func (s *Step) Workers() int {
+	return int(s.workers.Load())
+}
+
+// autoscale queues the input and starts between the policy's minimum and
+// maximum workers, adding and removing them as the queue and utilization
+// change. Each worker reads from its own relay so it can be stopped by
+// closing its input, letting it finish the items it has.
+func (s *Step) autoscale(ctx *Context, in <-chan interface{}, out chan interface{}, stop chan struct{}) {
+	policy := s.Autoscale
+	min, max := policy.bounds()
+	queue := make(chan interface{}, MaxBufferSize)
+	s.buffers = append(s.buffers, queue)
+	queued := make(chan struct{})
+	s.Go(func() error {
+		defer close(queued)
+		defer close(queue)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-stop:
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-stop:
+					return nil
+				case queue <- data:
+				}
+			}
+		}
+	})
+
+	s.wg = &sync.WaitGroup{}
+	quits := []chan struct{}{}
+	start := func() {
+		quit := make(chan struct{})
+		quits = append(quits, quit)
+		relay := make(chan interface{})
+		s.Go(func() error {
+			defer close(relay)
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-quit:
+					return nil
+				case data, ok := <-queue:
+					if !ok {
+						return nil
+					}
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-stop:
+						return nil
+					case relay <- data:
+					}
+				}
+			}
+		})
+		s.wg.Add(1)
+		s.workers.Add(1)
+		s.Go(func() (err error) {
+			defer s.wg.Done()
+			defer s.workers.Add(-1)
+			defer s.recover(ctx, &err)
+			return s.work(ctx, relay, out)
+		})
+	}
+	count := s.WorkerCount
+	if count < min {
+		count = min
+	} else if count > max {
+		count = max
+	}
+	// The monitor holds the wait group open while it may still add workers
+	s.wg.Add(1)
+	for i := 0; i < count; i++ {
+		start()
+	}
+	s.Go(func() error {
+		defer s.wg.Done()
+		ticker := time.NewTicker(policy.interval())
+		defer ticker.Stop()
+		var scaled time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-queued:
+				// Remaining workers drain the queue once the input ends
+				return nil
+			case now := <-ticker.C:
+				if len(quits) == 0 || now.Sub(scaled) < policy.cooldown() {
+					continue
+				}
+				backlog := len(queue)
+				workers := len(quits)
+				busy := float64(s.busy.Load()) / float64(workers)
+				if s.each == nil && backlog > 0 {
+					busy = 1
+				}
+				switch {
+				case backlog >= policy.backlog() && workers < max:
+					start()
+				case backlog == 0 && busy < policy.utilization() && workers > min:
+					close(quits[workers-1])
+					quits = quits[:workers-1]
+				default:
+					continue
+				}
+				scaled = now
+				ctx.notify(&State{Name: s.Name, Status: StatusStepScaled, Workers: len(quits)})
+			}
+		}
+	})
+}
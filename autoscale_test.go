@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func slowMap(ctx *Context, data interface{}) (interface{}, error) {
+	time.Sleep(5 * time.Millisecond)
+	return data, nil
+}
+
+func TestAutoscaleUp(t *testing.T) {
+	step := NewMapStep("slow", 1, slowMap)
+	step.Autoscale = &AutoscalePolicy{MaxWorkers: 4, Interval: 5 * time.Millisecond}
+	p := NewPipeline("autoscale", NewStage("stage", step))
+	values := []interface{}{}
+	for i := 0; i < 100; i++ {
+		values = append(values, i)
+	}
+	results, err := p.Run(nil, values...)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != len(values) {
+		t.Fatalf("expected %d results, found %d", len(values), len(results))
+	}
+	most := 0
+	for len(p.State()) > 0 {
+		state := <-p.State()
+		if state.Status == StatusStepScaled && state.Workers > most {
+			most = state.Workers
+		}
+	}
+	if most < 2 || most > 4 {
+		t.Fatalf("expected step to scale up to at most 4 workers, found %d", most)
+	}
+}
+
+func TestAutoscaleDown(t *testing.T) {
+	step := NewMapStep("slow", 4, slowMap)
+	step.Autoscale = &AutoscalePolicy{MinWorkers: 2, Interval: 5 * time.Millisecond}
+	in := make(chan interface{})
+	out := step.Process(&Context{context.Background(), nil}, in)
+	if workers := step.Workers(); workers != 4 {
+		t.Fatalf("expected step to start with 4 workers, found %d", workers)
+	}
+	deadline := time.Now().Add(time.Second)
+	for step.Workers() > 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if workers := step.Workers(); workers != 2 {
+		t.Fatalf("expected idle step to scale down to 2 workers, found %d", workers)
+	}
+	in <- 1
+	close(in)
+	if data := <-out; data != 1 {
+		t.Fatalf("expected item to pass through, found %v", data)
+	}
+	for range out {
+	}
+	step.Wait()
+}
+
+func TestMaxWorkerCount(t *testing.T) {
+	step := NewWorkerStep("many", MaxWorkerCount*2, passStep)
+	in := make(chan interface{})
+	out := step.Process(&Context{context.Background(), nil}, in)
+	if workers := step.Workers(); workers != MaxWorkerCount {
+		t.Fatalf("expected %d workers, found %d", MaxWorkerCount, workers)
+	}
+	close(in)
+	for range out {
+	}
+	step.Wait()
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"time"
+)
+
+// NewBatchStep creates a new step that groups items into []interface{}
+// batches. A batch is sent along once it holds maxSize items, once maxWait
+// has passed since its first item arrived, or when the input is closed.
+// Set maxSize or maxWait to 0 to flush only on the other conditions.
+// A partial batch is discarded if the context is cancelled.
+func NewBatchStep(name string, maxSize int, maxWait time.Duration) *Step {
+	return NewStep(name, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		b := &batcher{maxSize: maxSize, maxWait: maxWait}
+		defer b.stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-b.timeout:
+				if !b.flush(ctx, out) {
+					return nil
+				}
+			case data, ok := <-in:
+				if !ok {
+					b.flush(ctx, out)
+					return nil
+				}
+				b.add(ctx, data)
+				if b.full() && !b.flush(ctx, out) {
+					return nil
+				}
+			}
+		}
+	})
+}
+
+// batcher holds the batch being collected and the timer for flushing it.
+type batcher struct {
+	maxSize int
+	maxWait time.Duration
+	items   []interface{}
+	timer   *time.Timer
+	timeout <-chan time.Time
+}
+
+// add appends an item to the batch, starting the timer for a new batch.
+func (b *batcher) add(ctx *Context, data interface{}) {
+	b.items = append(b.items, data)
+	ctx.hold(1)
+	if len(b.items) == 1 && b.maxWait > 0 {
+		b.timer = time.NewTimer(b.maxWait)
+		b.timeout = b.timer.C
+	}
+}
+
+func (b *batcher) full() bool {
+	return b.maxSize > 0 && len(b.items) >= b.maxSize
+}
+
+// flush sends the batch if it has any items, returning false if the context
+// was done before it could be sent.
+func (b *batcher) flush(ctx *Context, out chan interface{}) bool {
+	b.stop()
+	if len(b.items) == 0 {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case out <- b.items:
+	}
+	ctx.hold(-len(b.items))
+	b.items = nil
+	return true
+}
+
+func (b *batcher) stop() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+		b.timeout = nil
+	}
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBatchStepSize(t *testing.T) {
+	step := NewBatchStep("batcher", 3, 0)
+	results := processItems(step, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	sizes := []int{3, 3, 3, 1}
+	if len(results) != len(sizes) {
+		t.Fatalf("expected %d batches, found %d", len(sizes), len(results))
+	}
+	next := 1
+	for i, data := range results {
+		batch := data.([]interface{})
+		if len(batch) != sizes[i] {
+			t.Fatalf("expected batch %d to hold %d items, found %d", i, sizes[i], len(batch))
+		}
+		for _, v := range batch {
+			if v.(int) != next {
+				t.Fatalf("expected %d, found %d", next, v)
+			}
+			next++
+		}
+	}
+	step.Wait()
+}
+
+func TestBatchStepWait(t *testing.T) {
+	step := NewBatchStep("batcher", 100, 10*time.Millisecond)
+	in := make(chan interface{})
+	out := step.Process(&Context{context.Background(), nil}, in)
+	in <- 1
+	in <- 2
+	select {
+	case data := <-out:
+		if batch := data.([]interface{}); len(batch) != 2 {
+			t.Fatalf("expected batch of 2 items, found %d", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected batch to flush after max wait")
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Fatal("expected no more batches")
+	}
+	step.Wait()
+}
+
+func TestBatchStepCancel(t *testing.T) {
+	step := NewBatchStep("batcher", 100, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan interface{})
+	out := step.Process(&Context{ctx, nil}, in)
+	in <- 1
+	cancel()
+	if _, ok := <-out; ok {
+		t.Fatal("expected partial batch to be discarded")
+	}
+	step.Wait()
+}
+
+func TestBatchStepProgress(t *testing.T) {
+	batch := NewBatchStep("batcher", 4, 0)
+	count := NewStep("counter", func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		for data := range in {
+			ctx.Add(len(data.([]interface{})))
+			out <- data
+		}
+		return nil
+	})
+	p := NewPipeline("batches", NewSerialStage("batch", batch, count))
+	p.Total(10)
+	if _, err := p.Run(nil, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if count, total, _ := p.CurrentAltProgress(); count != total {
+		t.Fatalf("expected %d units of work, found %d", total, count)
+	}
+}
@@ -25,3 +25,26 @@ func (c *Context) Inc() {
 		c.pipeline.Inc()
 	}
 }
+
+// Add increases the unit count by n and sends progress updates to listeners.
+// This is represented in the alternate progress and status updates.
+func (c *Context) Add(n int) {
+	if c.pipeline != nil {
+		c.pipeline.Add(n)
+	}
+}
+
+// report sends a state update to listeners of the pipeline, waiting for room
+// on the channel like other status updates.
+func (c *Context) report(state *State) {
+	if c.pipeline != nil {
+		c.pipeline.send(state, true)
+	}
+}
+
+// notify sends a state update to listeners of the pipeline.
+func (c *Context) notify(state *State) {
+	if c.pipeline != nil {
+		c.pipeline.notify(state)
+	}
+}
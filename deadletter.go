@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorMode determines how a step handles items that fail processing.
+type ErrorMode int
+
+const (
+	// ErrorModeKill stops the step with the item's error
+	ErrorModeKill ErrorMode = iota
+	// ErrorModeDeadLetter sends the failed item to the pipeline's dead letter
+	// channel and continues processing
+	ErrorModeDeadLetter
+)
+
+// ErrDeadLetterThreshold is returned when a pipeline has more failed items
+// than its MaxDeadLetters allows.
+var ErrDeadLetterThreshold = errors.New("pipeline: dead letter threshold exceeded")
+
+// FailedItem is an item that failed processing in a step.
+type FailedItem struct {
+	// Step is the name of the step the item failed in
+	Step string
+	// Input is the item that failed
+	Input interface{}
+	// Err is the error from the last attempt
+	Err error
+	// Attempt is the number of attempts made
+	Attempt int
+}
+
+// deadLetter sends a failed item to the pipeline's dead letter channel.
+// Items are discarded when the step isn't running in a pipeline.
+func (c *Context) deadLetter(item *FailedItem) error {
+	if c.pipeline != nil {
+		return c.pipeline.deadLetter(c, item)
+	}
+	return nil
+}
+
+// DeadLetters returns the channel of items that failed in steps using
+// ErrorModeDeadLetter. It must be read to keep those steps processing.
+func (p *Pipeline) DeadLetters() <-chan *FailedItem {
+	return p.deadLetterCh
+}
+
+// DeadLetterCount returns the number of items that have failed in steps
+// using ErrorModeDeadLetter.
+func (p *Pipeline) DeadLetterCount() int {
+	return int(p.deadLetterCount.Load())
+}
+
+func (p *Pipeline) deadLetter(ctx context.Context, item *FailedItem) error {
+	count := p.deadLetterCount.Add(1)
+	if p.MaxDeadLetters > 0 && count > int64(p.MaxDeadLetters) {
+		return fmt.Errorf("%w: %d items failed, last in %q: %v", ErrDeadLetterThreshold, count, item.Step, item.Err)
+	}
+	if p.parent != nil {
+		// Nested pipelines send failed items on to their parent
+		item.Step = p.path + "/" + item.Step
+		return p.parent.deadLetter(ctx, item)
+	}
+	select {
+	case <-ctx.Done():
+	case p.deadLetterCh <- item:
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+var errOdd = errors.New("odd number")
+
+func oddFailer(ctx *Context, data interface{}) (interface{}, error) {
+	if data.(int)%2 == 1 {
+		return nil, errOdd
+	}
+	return data, nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	step := NewMapStep("evens", 2, oddFailer)
+	step.ErrorMode = ErrorModeDeadLetter
+	p := NewPipeline("dead letters", NewStage("stage", step))
+	in := make(chan interface{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	out := p.Process(nil, in)
+	results := []interface{}{}
+	failed := []*FailedItem{}
+	for out != nil {
+		select {
+		case v, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			results = append(results, v)
+		case item := <-p.DeadLetters():
+			failed = append(failed, item)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	for len(failed) < 5 {
+		failed = append(failed, <-p.DeadLetters())
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, found %d", len(results))
+	}
+	for _, item := range failed {
+		if item.Step != "evens" || item.Input.(int)%2 != 1 || item.Err != errOdd || item.Attempt != 1 {
+			t.Fatalf("unexpected failed item %+v", item)
+		}
+	}
+	if n := p.DeadLetterCount(); n != 5 {
+		t.Fatalf("expected 5 dead letters, found %d", n)
+	}
+}
+
+func TestDeadLetterThreshold(t *testing.T) {
+	step := NewMapStep("evens", 1, oddFailer)
+	step.ErrorMode = ErrorModeDeadLetter
+	p := NewPipeline("dead letters", NewStage("stage", step))
+	p.MaxDeadLetters = 2
+	in := make(chan interface{}, 5)
+	for i := 1; i < 10; i += 2 {
+		in <- i
+	}
+	close(in)
+	for range p.Process(nil, in) {
+	}
+	if err := p.Wait(); !errors.Is(err, ErrDeadLetterThreshold) {
+		t.Fatalf("expected %v, found %v", ErrDeadLetterThreshold, err)
+	}
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+// Distribution is how a fan out step splits input across its workers.
+type Distribution int
+
+const (
+	// DistributionBroadcast sends every item to every worker.
+	DistributionBroadcast Distribution = iota
+	// DistributionRoundRobin sends each item to the next worker in turn.
+	DistributionRoundRobin
+	// DistributionLeastLoaded sends each item to the worker with the shortest
+	// queue. Each worker queues up to MaxBufferSize items.
+	DistributionLeastLoaded
+)
+
+// NewDistributedStep creates a new fan out step that splits input across
+// workers using the given distribution.
+func NewDistributedStep(name string, workerCount int, distribution Distribution, step func(*Context, <-chan interface{}, chan interface{}) error) *Step {
+	s := newStep(name, false, true, workerCount, step)
+	s.Distribution = distribution
+	return s
+}
+
+// WorkerItemCounts returns the number of items sent to each worker of a fan
+// out or partitioned step so far, or nil for steps whose workers share input.
+func (s *Step) WorkerItemCounts() []int {
+	if s.f == nil {
+		return nil
+	}
+	counts := make([]int, len(s.f.counts))
+	for i := range s.f.counts {
+		counts[i] = int(s.f.counts[i].Load())
+	}
+	return counts
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import "testing"
+
+func passStep(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+	for data := range in {
+		out <- data
+	}
+	return nil
+}
+
+func TestBroadcastDistribution(t *testing.T) {
+	step := NewDistributedStep("broadcast", 3, DistributionBroadcast, passStep)
+	results := processItems(step, 1, 2, 3, 4, 5)
+	if len(results) != 15 {
+		t.Fatalf("expected every worker to receive every item, found %d results", len(results))
+	}
+	for i, count := range step.WorkerItemCounts() {
+		if count != 5 {
+			t.Errorf("expected worker %d to receive 5 items, found %d", i, count)
+		}
+	}
+	step.Wait()
+}
+
+func TestRoundRobinDistribution(t *testing.T) {
+	step := NewDistributedStep("round robin", 4, DistributionRoundRobin, passStep)
+	values := []interface{}{}
+	for i := 0; i < 40; i++ {
+		values = append(values, i)
+	}
+	results := processItems(step, values...)
+	if len(results) != len(values) {
+		t.Fatalf("expected %d results, found %d", len(values), len(results))
+	}
+	for i, count := range step.WorkerItemCounts() {
+		if count != 10 {
+			t.Errorf("expected worker %d to receive 10 items, found %d", i, count)
+		}
+	}
+	step.Wait()
+}
+
+func TestLeastLoadedDistribution(t *testing.T) {
+	in := make(chan interface{})
+	f := fanLeastLoaded(in, 3)
+	// The first output is never read, like a worker stuck on an item, while
+	// the others are emptied after every item
+	fast := 0
+	drain := func() {
+		for _, out := range f.outs[1:] {
+			for len(out) > 0 {
+				<-out
+				fast++
+			}
+		}
+	}
+	for i := 0; i < 30; i++ {
+		// Once the fan takes an item it has placed the one before
+		in <- i
+		drain()
+	}
+	close(in)
+	f.Wait()
+	drain()
+	// The other outputs never hold more than one item when the fan picks, so
+	// the stuck output gets at most two
+	if slow := len(f.outs[0]); slow > 2 || slow+fast != 30 {
+		t.Fatalf("expected the stuck output to receive at most 2 of 30 items, found %d and %d", slow, fast)
+	}
+	if count := f.counts[0].Load(); count != int64(len(f.outs[0])) {
+		t.Fatalf("expected the stuck output count to be %d, found %d", len(f.outs[0]), count)
+	}
+}
+
+func TestWorkerItemCountsShared(t *testing.T) {
+	step := NewWorkerStep("shared", 2, passStep)
+	processItems(step, 1, 2)
+	if counts := step.WorkerItemCounts(); counts != nil {
+		t.Fatalf("expected no counts for shared input, found %v", counts)
+	}
+	step.Wait()
+}
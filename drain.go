@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrAborted is the reason a pipeline stops when it's aborted.
+var ErrAborted = errors.New("pipeline: aborted")
+
+// DrainError is returned by Drain when the pipeline didn't finish draining
+// in time and was aborted.
+type DrainError struct {
+	// Name of the pipeline
+	Name string
+	// Discarded is the number of items the pipeline held when it was aborted
+	Discarded int
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("%s: drain timed out, %d items discarded", e.Name, e.Discarded)
+}
+
+// Unwrap returns ErrAborted.
+func (e *DrainError) Unwrap() error {
+	return ErrAborted
+}
+
+// Drain stops the pipeline from taking more input and waits for the items
+// it already took to flow through to the output, which must still be read,
+// returning the pipeline's error. A paused pipeline is resumed so it can
+// finish. If ctx is done first the pipeline is aborted and a *DrainError
+// reports how many items were discarded.
+func (p *Pipeline) Drain(ctx context.Context) error {
+	if p.draining == nil {
+		return nil
+	}
+	p.drainOnce.Do(func() {
+		close(p.draining)
+	})
+	p.Resume()
+	select {
+	case <-p.Dead():
+		return p.Err()
+	case <-ctx.Done():
+		return &DrainError{Name: p.Name, Discarded: p.Abort()}
+	}
+}
+
+// Abort stops the pipeline at once with ErrAborted, discarding the items it
+// holds, and returns how many there were. Items are counted while they wait
+// between steps, in buffers and queues, or are being handled by map, filter
+// and flat map steps, or kept in reorder buffers, batches, windows and join
+// stores.
+func (p *Pipeline) Abort() int {
+	discarded := p.pending()
+	p.Kill(ErrAborted)
+	return discarded
+}
+
+// intake passes items from in to the first stages until in is closed, the
+// pipeline is draining, the context is done or the stages are done.
+func (p *Pipeline) intake(ctx *Context, in <-chan interface{}, stop <-chan struct{}) <-chan interface{} {
+	out := make(chan interface{})
+	draining := p.draining
+	p.Go(func() error {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-stop:
+				return nil
+			case <-draining:
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				p.held.Add(1)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-stop:
+					return nil
+				case out <- data:
+					p.held.Add(-1)
+				}
+			}
+		}
+	})
+	return out
+}
+
+// heldKey is the context key for the count of items held by a step.
+type heldKey struct{}
+
+// hold adds n to the items held by the step running with this context, for
+// step functions that keep items across reads, such as batches and windows.
+func (c *Context) hold(n int) {
+	if held, ok := c.Value(heldKey{}).(*atomic.Int64); ok {
+		held.Add(int64(n))
+	}
+}
+
+// pending counts the items held by the pipeline and its steps, including
+// those of nested pipelines.
+func (p *Pipeline) pending() int {
+	n := int(p.held.Load())
+	for _, stage := range p.stages {
+		n += int(stage.held.Load())
+		for _, step := range stage.steps {
+			n += step.pending()
+		}
+	}
+	for _, f := range p.fans {
+		n += f.pending()
+	}
+	return n
+}
+
+// pending counts the items held by the step.
+func (s *Step) pending() int {
+	n := int(s.held.Load() + s.busy.Load())
+	for _, buffer := range s.buffers {
+		n += len(buffer)
+	}
+	if s.f != nil {
+		n += s.f.pending()
+	}
+	if s.nested != nil {
+		n += s.nested.pending()
+	}
+	return n
+}
+
+// pending counts the items queued in the fan's outputs.
+func (f *fan) pending() int {
+	n := 0
+	for _, out := range f.outs {
+		n += len(out)
+	}
+	return n
+}
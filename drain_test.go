@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPipelineDrain(t *testing.T) {
+	p := NewPipeline("drain", NewSerialStage("stage", NewMapStep("slow", 2, slowMap)))
+	in := make(chan interface{})
+	out := p.Process(nil, in)
+	results := make(chan int)
+	go func() {
+		count := 0
+		for range out {
+			count++
+		}
+		results <- count
+	}()
+	for i := 0; i < 5; i++ {
+		in <- i
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("expected pipeline to drain, found %v", err)
+	}
+	if count := <-results; count != 5 {
+		t.Fatalf("expected all 5 items taken to reach the output, found %d", count)
+	}
+	select {
+	case in <- 5:
+		t.Fatal("expected drained pipeline to stop taking input")
+	default:
+	}
+}
+
+func TestPipelineDrainTimeout(t *testing.T) {
+	stuck := NewMapStep("stuck", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return data, nil
+	})
+	p := NewPipeline("drain", NewSerialStage("stage", stuck))
+	in := make(chan interface{})
+	out := p.Process(nil, in)
+	go func() {
+		for range out {
+		}
+	}()
+	// One item is handled, one waits for the step and one for the stage
+	for i := 0; i < 3; i++ {
+		in <- i
+	}
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := p.Drain(ctx)
+	var drainErr *DrainError
+	if !errors.As(err, &drainErr) {
+		t.Fatalf("expected a drain error, found %v", err)
+	}
+	if drainErr.Discarded != 3 {
+		t.Fatalf("expected 3 items discarded, found %d", drainErr.Discarded)
+	}
+	if err := p.Wait(); !errors.Is(err, ErrAborted) {
+		t.Fatalf("expected pipeline to be aborted, found %v", err)
+	}
+}
+
+func TestPipelineAbortKeptItems(t *testing.T) {
+	p := NewPipeline("abort",
+		NewSerialStage("batch", NewBatchStep("batch", 10, 0)),
+		NewStage("split", NewFlatMapStep("split", 1, func(ctx *Context, data interface{}) ([]interface{}, error) {
+			return data.([]interface{}), nil
+		})),
+		newOrderJoin(JoinInner),
+	)
+	in := make(chan interface{})
+	out := p.Process(nil, in)
+	go func() {
+		for range out {
+		}
+	}()
+	// Ten orders wait in the join store for payments and two in the batch
+	for i := 1; i <= 12; i++ {
+		in <- &record{"order", i}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if discarded := p.Abort(); discarded != 12 {
+		t.Fatalf("expected 12 items discarded, found %d", discarded)
+	}
+	p.Wait()
+}
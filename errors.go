@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StepError is an error returned by a single step.
+type StepError struct {
+	// Stage is the name of the stage the step belongs to
+	Stage string
+	// Step is the name of the step that failed
+	Step string
+	// Time the step failed
+	Time time.Time
+	// Err is the error returned by the step
+	Err error
+}
+
+func (e *StepError) Error() string {
+	if e.Stage == "" {
+		return fmt.Sprintf("%s: %v", e.Step, e.Err)
+	}
+	return fmt.Sprintf("%s/%s: %v", e.Stage, e.Step, e.Err)
+}
+
+// Unwrap returns the error returned by the step.
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// PipelineError collects every step error from a pipeline or stage.
+type PipelineError struct {
+	// Name of the pipeline or stage
+	Name string
+	// Errors in the order they occurred
+	Errors []*StepError
+}
+
+func (e *PipelineError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("%s: %v", e.Name, e.Errors[0])
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%s: %d errors: %s", e.Name, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the step errors so they can be matched with errors.Is and errors.As.
+func (e *PipelineError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// TimeoutError is returned when a step or a single item takes longer than
+// its timeout.
+type TimeoutError struct {
+	// Step is the name of the step that timed out
+	Step string
+	// Timeout that passed
+	Timeout time.Duration
+	// Item that timed out, nil if the whole step timed out
+	Item interface{}
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Item == nil {
+		return fmt.Sprintf("%s: timed out after %v", e.Step, e.Timeout)
+	}
+	return fmt.Sprintf("%s: item %v timed out after %v", e.Step, e.Item, e.Timeout)
+}
+
+// Unwrap returns context.DeadlineExceeded.
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// PanicError is returned when a step panics.
+type PanicError struct {
+	// Step is the name of the step that panicked
+	Step string
+	// Value passed to panic
+	Value interface{}
+	// Stack trace of the go routine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: panic: %v", e.Step, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it was an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// discard drops the rest of the input of a step or stage that failed while
+// its pipeline collects errors, so whatever sends to it isn't left blocked.
+// It stops once the input is closed or the pipeline is dying.
+func discard(ctx *Context, in <-chan interface{}) {
+	p := ctx.pipeline
+	if p == nil || !p.CollectErrors {
+		return
+	}
+	for {
+		select {
+		case <-p.Dying():
+			return
+		case _, ok := <-in:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// errorList collects step errors from multiple go routines.
+type errorList struct {
+	mu   sync.Mutex
+	errs []*StepError
+}
+
+func (l *errorList) add(errs ...*StepError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, errs...)
+}
+
+// reset clears the collected errors.
+func (l *errorList) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = nil
+}
+
+// err returns a *PipelineError with the collected errors, or nil if there are none.
+func (l *errorList) err(name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.errs) == 0 {
+		return nil
+	}
+	errs := make([]*StepError, len(l.errs))
+	copy(errs, l.errs)
+	return &PipelineError{name, errs}
+}
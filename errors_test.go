@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func failingStep(err error) *Step {
+	return NewStep("failing", func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		return err
+	})
+}
+
+func waitingStep(name string) *Step {
+	return NewStep(name, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		<-ctx.Done()
+		return nil
+	})
+}
+
+func TestPipelineErrorUnwrap(t *testing.T) {
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+	err := error(&PipelineError{"pipeline", []*StepError{
+		{"stage", "one", time.Now(), errFirst},
+		{"stage", "two", time.Now(), errSecond},
+	}})
+	if !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Fatal("expected pipeline error to match both step errors")
+	}
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "one" {
+		t.Fatalf("expected first step error, found %v", stepErr)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "2 errors") || !strings.Contains(msg, "stage/two: second") {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+}
+
+func TestPipelineFailFast(t *testing.T) {
+	errBad := errors.New("bad")
+	stage := NewConcurrentStage("stage", failingStep(errBad), waitingStep("waiting"))
+	p := NewPipeline("fail fast", stage)
+	in := make(chan interface{})
+	defer close(in)
+	for range p.Process(nil, in) {
+	}
+	err := p.Wait()
+	var pipelineErr *PipelineError
+	if !errors.As(err, &pipelineErr) || len(pipelineErr.Errors) != 1 {
+		t.Fatalf("expected a pipeline error with 1 error, found %v", err)
+	}
+	if stepErr := pipelineErr.Errors[0]; stepErr.Stage != "stage" || stepErr.Step != "failing" || stepErr.Err != errBad || stepErr.Time.IsZero() {
+		t.Fatalf("unexpected step error %+v", stepErr)
+	}
+}
+
+func TestPipelineCollectErrors(t *testing.T) {
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+	first := NewStage("first", failingStep(errFirst))
+	second := NewStage("second", failingStep(errSecond))
+	p := NewPipeline("collect all", first, second)
+	p.CollectErrors = true
+	in := make(chan interface{})
+	defer close(in)
+	for range p.Process(nil, in) {
+	}
+	err := p.Wait()
+	if !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Fatalf("expected both errors to be collected, found %v", err)
+	}
+}
+
+func TestPipelineCollectErrorsMidStream(t *testing.T) {
+	for _, autoscale := range []*AutoscalePolicy{nil, {MinWorkers: 1, MaxWorkers: 2}} {
+		first := NewStage("first", NewMapStep("pass", 1, passMap))
+		fail := NewMapStep("fail", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+			return nil, errBadItem
+		})
+		fail.Autoscale = autoscale
+		p := NewPipeline("collect all", first, NewStage("second", fail))
+		p.CollectErrors = true
+		in := make(chan interface{})
+		go func() {
+			defer close(in)
+			for i := 0; i < 10; i++ {
+				in <- i
+			}
+		}()
+		done := make(chan error)
+		go func() {
+			for range p.Process(nil, in) {
+			}
+			done <- p.Wait()
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, errBadItem) {
+				t.Fatalf("expected step error to be collected, found %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected pipeline to finish after a step failed, autoscale %v", autoscale)
+		}
+	}
+}
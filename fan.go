@@ -1,6 +1,9 @@
 package pipeline
 
 import (
+	"sync"
+	"sync/atomic"
+
 	tomb "gopkg.in/tomb.v2"
 )
 
@@ -9,6 +12,12 @@ type fan struct {
 	ins  []chan interface{}
 	out  chan interface{}
 	outs []chan interface{}
+	// counts of items sent to each of the outs
+	counts []atomic.Int64
+	// next is the out to start from when picking in turn
+	next int
+	// closed ensures the outputs are only closed once
+	closed sync.Once
 }
 
 // fanIn combines multiple channels into a single output channel.
@@ -25,11 +34,15 @@ func fanIn(ins ...chan interface{}) *fan {
 				select {
 				case <-f.Dying():
 					return nil
-				case data := <-in:
-					if data == nil {
+				case data, ok := <-in:
+					if !ok {
+						return nil
+					}
+					select {
+					case <-f.Dying():
 						return nil
+					case f.out <- data:
 					}
-					f.out <- data
 				}
 			}
 		})
@@ -39,41 +52,97 @@ func fanIn(ins ...chan interface{}) *fan {
 
 // fanOut splits a single channel into a number of output channels.
 func fanOut(in <-chan interface{}, num int) *fan {
+	return fanPick(in, num, 0, nil)
+}
+
+// fanRoundRobin splits a single channel into a number of output channels,
+// sending each item to the next output in turn.
+func fanRoundRobin(in <-chan interface{}, num int) *fan {
+	return fanPick(in, num, 0, func(f *fan, data interface{}) int {
+		index := f.next
+		f.next = (f.next + 1) % len(f.outs)
+		return index
+	})
+}
+
+// fanLeastLoaded splits a single channel into a number of buffered output
+// channels, sending each item to the output with the fewest queued items.
+func fanLeastLoaded(in <-chan interface{}, num int) *fan {
+	return fanPick(in, num, MaxBufferSize, func(f *fan, data interface{}) int {
+		// Start from the output after the last one picked to break ties fairly
+		index := f.next
+		for i := 1; i < len(f.outs); i++ {
+			candidate := (f.next + i) % len(f.outs)
+			if len(f.outs[candidate]) < len(f.outs[index]) {
+				index = candidate
+			}
+		}
+		f.next = (index + 1) % len(f.outs)
+		return index
+	})
+}
+
+// fanPick splits a single channel into a number of output channels with the
+// given buffer size, sending each item only to the output picked for it, or
+// every output if pick is nil. Items picked for a negative index are dropped.
+func fanPick(in <-chan interface{}, num int, size int, pick func(*fan, interface{}) int) *fan {
 	f := &fan{}
 	f.outs = make([]chan interface{}, num)
+	f.counts = make([]atomic.Int64, num)
 	for i := 0; i < num; i++ {
-		f.outs[i] = make(chan interface{})
+		f.outs[i] = make(chan interface{}, size)
 	}
 	defer f.teardown()
 	f.Go(func() error {
-		for data := range in {
-			f.sendOut(data)
+		for {
+			select {
+			case <-f.Dying():
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				if pick == nil {
+					f.sendOut(data)
+				} else if index := pick(f, data); index >= 0 {
+					f.sendTo(index, data)
+				}
+			}
 		}
-		return nil
 	})
 	return f
 }
 
 func (f *fan) sendOut(input interface{}) {
-	for _, out := range f.outs {
+	for i, out := range f.outs {
 		select {
 		case <-f.Dying():
 			return
 		case out <- input:
+			f.counts[i].Add(1)
 		}
 	}
 }
 
+func (f *fan) sendTo(index int, input interface{}) {
+	select {
+	case <-f.Dying():
+	case f.outs[index] <- input:
+		f.counts[index].Add(1)
+	}
+}
+
+// close closes the fan's output channels once. Callers may still be reading
+// the channel fields so they are left in place.
 func (f *fan) close() {
-	if f.out != nil {
-		close(f.out)
-		f.out = nil
-	} else if f.outs != nil {
+	f.closed.Do(func() {
+		if f.out != nil {
+			close(f.out)
+		}
 		for _, out := range f.outs {
 			close(out)
 		}
-		f.outs = nil
-	}
+	})
 }
 
 func (f *fan) teardown() {
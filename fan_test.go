@@ -71,3 +71,26 @@ func TestFanOut(t *testing.T) {
 		t.Fatalf("expected 25 results, found %d", len(results))
 	}
 }
+
+func TestFanInNil(t *testing.T) {
+	ins := []chan interface{}{make(chan interface{}), make(chan interface{})}
+	f := fanIn(ins...)
+	for _, in := range ins {
+		go func(in chan interface{}) {
+			in <- nil
+			in <- 1
+			close(in)
+		}(in)
+	}
+	nils, ints := 0, 0
+	for data := range f.out {
+		if data == nil {
+			nils++
+		} else {
+			ints++
+		}
+	}
+	if nils != 2 || ints != 2 {
+		t.Fatalf("expected 2 nils and 2 ints, found %d and %d", nils, ints)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/pokanop/pipeline
 
-go 1.12
+go 1.21
 
 require (
 	golang.org/x/net v0.0.0-20190912160710-24e19bdeb0f2 // indirect
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCycle is returned when connecting stages would create a cycle.
+var ErrCycle = errors.New("pipeline: graph contains a cycle")
+
+// Graph is a pipeline whose stages form a directed acyclic graph instead of
+// a linear list. Stages without incoming connections receive the pipeline
+// input, stages with several incoming connections merge them, stages with
+// several outgoing connections send every item to each of them, join stages
+// with two incoming connections feed one to each branch, and the output of
+// stages without outgoing connections is merged into the pipeline output.
+type Graph struct {
+	*Pipeline
+}
+
+// NewGraph creates a new graph based pipeline.
+func NewGraph(name string) *Graph {
+	p := NewPipeline(name)
+	p.edges = map[*Stage][]*Stage{}
+	p.inbound = map[*Stage][]*Stage{}
+	return &Graph{p}
+}
+
+// AddNode adds a stage to the graph, stage names must be unique.
+func (g *Graph) AddNode(stage *Stage) error {
+	if g.stage(stage.Name) != nil {
+		return fmt.Errorf("pipeline: duplicate stage %q", stage.Name)
+	}
+	g.AddStage(stage)
+	return nil
+}
+
+// Connect sends the output of the from stage to the input of the to stage.
+func (g *Graph) Connect(from, to string) error {
+	src, dst := g.stage(from), g.stage(to)
+	if src == nil {
+		return fmt.Errorf("pipeline: unknown stage %q", from)
+	}
+	if dst == nil {
+		return fmt.Errorf("pipeline: unknown stage %q", to)
+	}
+	for _, next := range g.edges[src] {
+		if next == dst {
+			return fmt.Errorf("pipeline: stages %q and %q are already connected", from, to)
+		}
+	}
+	if src == dst || g.reaches(dst, src) {
+		return fmt.Errorf("%w: %q -> %q", ErrCycle, from, to)
+	}
+	g.edges[src] = append(g.edges[src], dst)
+	g.inbound[dst] = append(g.inbound[dst], src)
+	return nil
+}
+
+func (g *Graph) stage(name string) *Stage {
+	for _, stage := range g.stages {
+		if stage.Name == name {
+			return stage
+		}
+	}
+	return nil
+}
+
+// reaches reports whether there is a path from one stage to another.
+func (g *Graph) reaches(from, to *Stage) bool {
+	if from == to {
+		return true
+	}
+	for _, next := range g.edges[from] {
+		if g.reaches(next, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// topology returns the stages in topological order.
+func (p *Pipeline) topology() []*Stage {
+	inDegree := map[*Stage]int{}
+	for _, nexts := range p.edges {
+		for _, next := range nexts {
+			inDegree[next]++
+		}
+	}
+	order := []*Stage{}
+	for _, stage := range p.stages {
+		if inDegree[stage] == 0 {
+			order = append(order, stage)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, next := range p.edges[order[i]] {
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				order = append(order, next)
+			}
+		}
+	}
+	return order
+}
+
+// processGraph starts stages in topological order, wiring each one's input
+// to the output of the stages connected to it.
+func (p *Pipeline) processGraph(ctx *Context, in <-chan interface{}) chan interface{} {
+	order := p.topology()
+	// inputs of each stage by the stage they come from, nil for the pipeline input
+	inputs := map[*Stage]map[*Stage]chan interface{}{}
+	connect := func(from, to *Stage, ch chan interface{}) {
+		if inputs[to] == nil {
+			inputs[to] = map[*Stage]chan interface{}{}
+		}
+		inputs[to][from] = ch
+	}
+	sources := []*Stage{}
+	for _, stage := range order {
+		if !p.hasInput(stage) {
+			sources = append(sources, stage)
+		}
+	}
+	if len(sources) > 1 {
+		f := fanOut(in, len(sources))
+		p.fans = append(p.fans, f)
+		for i, stage := range sources {
+			connect(nil, stage, f.outs[i])
+		}
+	}
+	sinks := []chan interface{}{}
+	for _, stage := range order {
+		var stageIn, right <-chan interface{}
+		froms := p.inbound[stage]
+		switch {
+		case len(froms) == 0 && inputs[stage] == nil:
+			stageIn = in
+		case len(froms) == 0:
+			stageIn = inputs[stage][nil]
+		case len(froms) == 1:
+			stageIn = inputs[stage][froms[0]]
+		case len(froms) == 2 && stage.join != nil:
+			// Each connection feeds its own branch of a join
+			stageIn, right = inputs[stage][froms[0]], inputs[stage][froms[1]]
+		default:
+			ins := make([]chan interface{}, len(froms))
+			for i, from := range froms {
+				ins[i] = inputs[stage][from]
+			}
+			f := fanIn(ins...)
+			p.fans = append(p.fans, f)
+			stageIn = f.out
+		}
+		p.updateStatus(stage.Name, StatusStageStarted)
+		out := stage.process(ctx, stageIn, right)
+		switch nexts := p.edges[stage]; len(nexts) {
+		case 0:
+			sinks = append(sinks, out)
+		case 1:
+			connect(stage, nexts[0], out)
+		default:
+			f := fanOut(out, len(nexts))
+			p.fans = append(p.fans, f)
+			for i, next := range nexts {
+				connect(stage, next, f.outs[i])
+			}
+		}
+	}
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	f := fanIn(sinks...)
+	p.fans = append(p.fans, f)
+	return f.out
+}
+
+func (p *Pipeline) hasInput(stage *Stage) bool {
+	for _, nexts := range p.edges {
+		for _, next := range nexts {
+			if next == stage {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func multiplyStage(name string, n int) *Stage {
+	return NewStage(name, NewMapStep(name, 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data.(int) * n, nil
+	}))
+}
+
+func TestGraphDiamond(t *testing.T) {
+	g := NewGraph("diamond")
+	for _, stage := range []*Stage{multiplyStage("a", 1), multiplyStage("b", 2), multiplyStage("c", 3), multiplyStage("d", 1)} {
+		if err := g.AddNode(stage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, edge := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}} {
+		if err := g.Connect(edge[0], edge[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := g.Run(context.Background(), 1, 2, 3, 4)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	total := 0
+	for _, v := range results {
+		total += v.(int)
+	}
+	if len(results) != 8 || total != 50 {
+		t.Fatalf("expected 8 results totaling 50, found %d totaling %d", len(results), total)
+	}
+}
+
+func TestGraphSideOutput(t *testing.T) {
+	// a feeds b and also skips straight to c
+	g := NewGraph("side output")
+	g.AddNode(multiplyStage("a", 1))
+	g.AddNode(multiplyStage("b", 10))
+	g.AddNode(multiplyStage("c", 1))
+	g.Connect("a", "b")
+	g.Connect("b", "c")
+	g.Connect("a", "c")
+	results, err := g.Run(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	total := 0
+	for _, v := range results {
+		total += v.(int)
+	}
+	if len(results) != 4 || total != 33 {
+		t.Fatalf("expected 4 results totaling 33, found %d totaling %d", len(results), total)
+	}
+}
+
+func TestGraphMultipleSources(t *testing.T) {
+	g := NewGraph("sources")
+	g.AddNode(multiplyStage("a", 1))
+	g.AddNode(multiplyStage("b", 2))
+	results, err := g.Run(context.Background(), 1, 2, 3)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, found %d", len(results))
+	}
+}
+
+func TestGraphConnectErrors(t *testing.T) {
+	g := NewGraph("errors")
+	g.AddNode(multiplyStage("a", 1))
+	g.AddNode(multiplyStage("b", 1))
+	g.AddNode(multiplyStage("c", 1))
+	if err := g.AddNode(multiplyStage("a", 1)); err == nil {
+		t.Error("expected duplicate stage error")
+	}
+	if err := g.Connect("a", "z"); err == nil {
+		t.Error("expected unknown stage error")
+	}
+	g.Connect("a", "b")
+	g.Connect("b", "c")
+	if err := g.Connect("a", "b"); err == nil {
+		t.Error("expected duplicate connection error")
+	}
+	if err := g.Connect("c", "a"); !errors.Is(err, ErrCycle) {
+		t.Errorf("expected cycle error, found %v", err)
+	}
+	if err := g.Connect("a", "a"); !errors.Is(err, ErrCycle) {
+		t.Errorf("expected cycle error, found %v", err)
+	}
+}
+
+func TestGraphTopology(t *testing.T) {
+	g := NewGraph("topology")
+	g.AddNode(multiplyStage("d", 1))
+	g.AddNode(multiplyStage("c", 1))
+	g.AddNode(multiplyStage("b", 1))
+	g.AddNode(multiplyStage("a", 1))
+	g.Connect("a", "b")
+	g.Connect("b", "c")
+	g.Connect("c", "d")
+	order := g.topology()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if order[i].Name != name {
+			t.Fatalf("expected %s at position %d, found %s", name, i, order[i].Name)
+		}
+	}
+}
+
+func TestGraphError(t *testing.T) {
+	g := NewGraph("failing")
+	g.AddNode(multiplyStage("a", 1))
+	g.AddNode(NewStage("b", NewMapStep("b", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return nil, errBadItem
+	})))
+	g.AddNode(multiplyStage("c", 1))
+	g.Connect("a", "b")
+	g.Connect("a", "c")
+	_, err := g.Run(context.Background(), 1, 2, 3)
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Stage != "b" || stepErr.Err != errBadItem {
+		t.Fatalf("expected error from stage b, found %v", err)
+	}
+}
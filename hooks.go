@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+// StartFn is called before a pipeline, stage or step starts processing.
+// Returning an error fails it without processing any items.
+type StartFn func(*Context) error
+
+// FinishFn is called once a pipeline, stage or step is done, with the error
+// it failed with, if any. Returning an error fails it if it hadn't already.
+type FinishFn func(*Context, error) error
+
+// SetupFn is called by each worker of a step before it starts, returning
+// state for the worker that its step function gets from the context's
+// WorkerState. Returning an error fails the worker.
+type SetupFn func(*Context) (interface{}, error)
+
+// TeardownFn is called by each worker of a step once it's done, with the
+// state returned by the step's SetupFn.
+type TeardownFn func(*Context, interface{}) error
+
+// workerStateKey is the context key for per worker state.
+type workerStateKey struct{}
+
+// WorkerState returns the state the step's SetupFn returned for the worker
+// running with this context, or nil if there is none.
+func (c *Context) WorkerState() interface{} {
+	return c.Value(workerStateKey{})
+}
+
+// detach returns a context that isn't cancelled with ctx, for hooks that
+// clean up after processing stopped.
+func detach(ctx *Context) *Context {
+	return &Context{context.WithoutCancel(ctx), ctx.pipeline}
+}
+
+// work runs a single worker of the step between its setup and teardown hooks.
+func (s *Step) work(ctx *Context, in <-chan interface{}, out chan interface{}) (err error) {
+	var state interface{}
+	if s.Setup != nil {
+		if state, err = s.Setup(ctx); err != nil {
+			return err
+		}
+	}
+	if s.Teardown != nil {
+		defer func() {
+			if teardownErr := s.Teardown(detach(ctx), state); err == nil {
+				err = teardownErr
+			}
+		}()
+	}
+	ctx = &Context{context.WithValue(ctx, heldKey{}, &s.held), ctx.pipeline}
+	if state != nil {
+		ctx = &Context{context.WithValue(ctx, workerStateKey{}, state), ctx.pipeline}
+	}
+	return s.run(ctx, in, out)
+}
+
+// start calls the step's OnStart hook, recovering a panic as a *PanicError.
+func (s *Step) start(ctx *Context) (err error) {
+	if s.OnStart == nil {
+		return nil
+	}
+	defer s.recover(ctx, &err)
+	return s.OnStart(ctx)
+}
+
+// finish calls the step's OnFinish hook with the error it stopped with,
+// recovering a panic as a *PanicError.
+func (s *Step) finish(ctx *Context) (err error) {
+	if s.OnFinish == nil {
+		return nil
+	}
+	defer s.recover(ctx, &err)
+	stopErr := s.Err()
+	if stopErr == tomb.ErrStillAlive {
+		stopErr = nil
+	}
+	return s.OnFinish(detach(ctx), stopErr)
+}
+
+// start calls the stage's OnStart hook, recovering a panic as a *PanicError.
+func (s *Stage) start(ctx *Context) (err error) {
+	if s.OnStart == nil {
+		return nil
+	}
+	defer s.recover(ctx, &err)
+	return s.OnStart(ctx)
+}
+
+// finish calls the stage's OnFinish hook with the errors of its steps,
+// returning them along with any error from the hook.
+func (s *Stage) finish(ctx *Context) error {
+	err := s.errs.err(s.Name)
+	if s.OnFinish == nil {
+		return err
+	}
+	if finishErr := s.onFinish(ctx, err); finishErr != nil && err == nil {
+		s.errs.add(&StepError{s.Name, "finish", time.Now(), finishErr})
+	}
+	return s.errs.err(s.Name)
+}
+
+func (s *Stage) onFinish(ctx *Context, stopErr error) (err error) {
+	defer s.recover(ctx, &err)
+	return s.OnFinish(detach(ctx), stopErr)
+}
+
+// start calls the pipeline's OnStart hook, recovering a panic as a *PanicError.
+func (p *Pipeline) start(ctx *Context) (err error) {
+	if p.OnStart == nil {
+		return nil
+	}
+	defer p.recover(ctx, &err)
+	return p.OnStart(ctx)
+}
+
+// finish calls the pipeline's OnFinish hook with the errors of its stages,
+// recording any error from the hook.
+func (p *Pipeline) finish(ctx *Context) {
+	if p.OnFinish == nil {
+		return
+	}
+	err := p.errs.err(p.Name)
+	if finishErr := p.onFinish(ctx, err); finishErr != nil && err == nil {
+		p.errs.add(&StepError{Step: "finish", Time: time.Now(), Err: finishErr})
+	}
+}
+
+func (p *Pipeline) onFinish(ctx *Context, stopErr error) (err error) {
+	defer p.recover(ctx, &err)
+	return p.OnFinish(detach(ctx), stopErr)
+}
+
+// recover converts a panic in a hook of the pipeline into a *PanicError and
+// reports it on the state channel. It must be deferred.
+func (p *Pipeline) recover(ctx *Context, err *error) {
+	if r := recover(); r != nil {
+		panicErr := &PanicError{p.Name, r, debug.Stack()}
+		ctx.report(&State{Name: p.Name, Status: StatusStepPanicked, Err: panicErr})
+		*err = panicErr
+	}
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+type connection struct {
+	items int
+}
+
+func TestStepHooks(t *testing.T) {
+	mu := sync.Mutex{}
+	events := []string{}
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	handled := 0
+	step := NewMapStep("hooks", 2, func(ctx *Context, data interface{}) (interface{}, error) {
+		ctx.WorkerState().(*connection).items++
+		return data, nil
+	})
+	step.OnStart = func(ctx *Context) error {
+		record("start")
+		return nil
+	}
+	step.Setup = func(ctx *Context) (interface{}, error) {
+		record("setup")
+		return &connection{}, nil
+	}
+	step.Teardown = func(ctx *Context, state interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled += state.(*connection).items
+		events = append(events, "teardown")
+		return nil
+	}
+	step.OnFinish = func(ctx *Context, err error) error {
+		record("finish")
+		return err
+	}
+	p := NewPipeline("hooks", NewSerialStage("stage", step))
+	if _, err := p.Run(nil, 1, 2, 3, 4, 5); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if handled != 5 {
+		t.Fatalf("expected workers to handle 5 items, found %d", handled)
+	}
+	if len(events) != 6 || events[0] != "start" || events[5] != "finish" {
+		t.Fatalf("expected start, 2 setups, 2 teardowns and finish, found %v", events)
+	}
+}
+
+func TestStepHookErrors(t *testing.T) {
+	step := NewMapStep("hooks", 1, passMap)
+	step.OnStart = func(ctx *Context) error {
+		return errBadItem
+	}
+	p := NewPipeline("hooks", NewSerialStage("stage", step))
+	if _, err := p.Run(nil, 1); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected start error, found %v", err)
+	}
+
+	var finished error
+	failing := failingStep(errBadItem)
+	failing.OnFinish = func(ctx *Context, err error) error {
+		finished = err
+		return nil
+	}
+	p = NewPipeline("hooks", NewSerialStage("stage", failing))
+	p.Run(nil, 1)
+	if !errors.Is(finished, errBadItem) {
+		t.Fatalf("expected finish hook to get the step error, found %v", finished)
+	}
+}
+
+func TestPipelineAndStageHooks(t *testing.T) {
+	events := []string{}
+	stage := NewSerialStage("stage", NewMapStep("pass", 1, passMap))
+	stage.OnStart = func(ctx *Context) error {
+		events = append(events, "stage start")
+		return nil
+	}
+	stage.OnFinish = func(ctx *Context, err error) error {
+		events = append(events, "stage finish")
+		return err
+	}
+	p := NewPipeline("hooks", stage)
+	p.OnStart = func(ctx *Context) error {
+		events = append(events, "pipeline start")
+		return nil
+	}
+	p.OnFinish = func(ctx *Context, err error) error {
+		events = append(events, "pipeline finish")
+		return errBadItem
+	}
+	if _, err := p.Run(nil, 1, 2); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected finish error, found %v", err)
+	}
+	expected := []string{"pipeline start", "stage start", "stage finish", "pipeline finish"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v, found %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected %v, found %v", expected, events)
+		}
+	}
+}
+
+func TestStageStartError(t *testing.T) {
+	stage := NewSerialStage("stage", NewMapStep("pass", 1, passMap))
+	stage.OnStart = func(ctx *Context) error {
+		return errBadItem
+	}
+	p := NewPipeline("hooks", stage)
+	if _, err := p.Run(nil, 1); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected start error, found %v", err)
+	}
+}
+
+func TestPipelineStartError(t *testing.T) {
+	p := NewPipeline("hooks", NewSerialStage("stage", NewMapStep("pass", 1, passMap)))
+	p.OnStart = func(ctx *Context) error {
+		return errBadItem
+	}
+	if _, err := p.Run(nil, 1); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected start error, found %v", err)
+	}
+}
+
+func TestHookPanics(t *testing.T) {
+	panics := func(ctx *Context, err error) error {
+		panic("finish")
+	}
+	autoscaled := NewMapStep("autoscaled", 1, passMap)
+	autoscaled.Autoscale = &AutoscalePolicy{MaxWorkers: 2}
+	autoscaled.OnFinish = panics
+	step := NewMapStep("step", 1, passMap)
+	step.OnFinish = panics
+	stage := NewSerialStage("stage", NewMapStep("pass", 1, passMap))
+	stage.OnFinish = panics
+	pipelineFinish := NewPipeline("pipeline", NewSerialStage("stage", NewMapStep("pass", 1, passMap)))
+	pipelineFinish.OnFinish = panics
+	stepStart := NewMapStep("start", 1, passMap)
+	stepStart.OnStart = func(ctx *Context) error {
+		panic("start")
+	}
+	for name, p := range map[string]*Pipeline{
+		"autoscaled step": NewPipeline("hooks", NewSerialStage("stage", autoscaled)),
+		"step":            NewPipeline("hooks", NewSerialStage("stage", step)),
+		"stage":           NewPipeline("hooks", stage),
+		"pipeline":        pipelineFinish,
+		"step start":      NewPipeline("hooks", NewSerialStage("stage", stepStart)),
+	} {
+		_, err := p.Run(nil, 1, 2)
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("%s: expected a panic error from the hook, found %v", name, err)
+		}
+	}
+}
+
+func TestStageFinishError(t *testing.T) {
+	stage := NewSerialStage("st", NewMapStep("pass", 1, passMap))
+	stage.OnFinish = func(ctx *Context, err error) error {
+		return errors.New("flush failed")
+	}
+	_, err := NewPipeline("p", stage).Run(nil, 1)
+	if err == nil || err.Error() != "p: st/finish: flush failed" {
+		t.Fatalf("expected the stage finish error, found %v", err)
+	}
+}
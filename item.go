@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"context"
+)
+
+// MapFn transforms a single item into a new item.
+type MapFn func(*Context, interface{}) (interface{}, error)
+
+// FilterFn reports whether a single item should be passed along.
+type FilterFn func(*Context, interface{}) (bool, error)
+
+// FlatMapFn transforms a single item into any number of items.
+type FlatMapFn func(*Context, interface{}) ([]interface{}, error)
+
+// itemFn is the common form of the per item functions.
+type itemFn func(*Context, interface{}) ([]interface{}, error)
+
+// NewMapStep creates a new step that applies fn to every item.
+func NewMapStep(name string, workerCount int, fn MapFn) *Step {
+	return newItemStep(name, workerCount, func(ctx *Context, data interface{}) ([]interface{}, error) {
+		result, err := fn(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{result}, nil
+	})
+}
+
+// NewFilterStep creates a new step that only passes along items for which fn returns true.
+func NewFilterStep(name string, workerCount int, fn FilterFn) *Step {
+	return newItemStep(name, workerCount, func(ctx *Context, data interface{}) ([]interface{}, error) {
+		keep, err := fn(ctx, data)
+		if err != nil || !keep {
+			return nil, err
+		}
+		return []interface{}{data}, nil
+	})
+}
+
+// NewFlatMapStep creates a new step that passes along every item returned by fn.
+func NewFlatMapStep(name string, workerCount int, fn FlatMapFn) *Step {
+	return newItemStep(name, workerCount, itemFn(fn))
+}
+
+func newItemStep(name string, workerCount int, fn itemFn) *Step {
+	s := newStep(name, false, false, workerCount, nil)
+	s.each = fn
+	return s
+}
+
+// processItem runs the item function, retrying failures according to the
+// step's retry policy. It returns the number of attempts made.
+func (s *Step) processItem(ctx *Context, data interface{}) ([]interface{}, int, error) {
+	for attempt := 1; ; attempt++ {
+		results, err := s.call(ctx, data)
+		if err == nil || !s.Retry.shouldRetry(err, attempt) {
+			return results, attempt, err
+		}
+		ctx.report(&State{Name: s.Name, Status: StatusStepRetried, Err: err, Attempt: attempt})
+		if !s.Retry.wait(ctx, attempt) {
+			return nil, attempt, err
+		}
+	}
+}
+
+// call runs the item function for a single attempt, recovering any panic.
+func (s *Step) call(ctx *Context, data interface{}) (results []interface{}, err error) {
+	defer s.recover(ctx, &err)
+	if s.ItemTimeout <= 0 {
+		return s.each(ctx, data)
+	}
+	timeout := &TimeoutError{Step: s.Name, Timeout: s.ItemTimeout, Item: data}
+	d, cancel := context.WithTimeoutCause(ctx, s.ItemTimeout, timeout)
+	defer cancel()
+	results, err = s.each(&Context{d, ctx.pipeline}, data)
+	if context.Cause(d) == timeout {
+		return nil, timeout
+	}
+	return results, err
+}
+
+// forEach reads items until the input is closed or the context is done,
+// sending the results of the item function along.
+func (s *Step) forEach(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-in:
+			if !ok {
+				return nil
+			}
+			s.busy.Add(1)
+			stop, err := s.handle(ctx, data, out)
+			s.busy.Add(-1)
+			if stop {
+				return err
+			}
+			ctx.itemDone()
+		}
+	}
+}
+
+// handle processes a single item and sends its results along. It returns
+// true if the worker should stop, along with the error that stopped it.
+func (s *Step) handle(ctx *Context, data interface{}, out chan interface{}) (bool, error) {
+	results, attempt, err := s.processItem(ctx, data)
+	if err != nil {
+		if s.ErrorMode != ErrorModeDeadLetter {
+			return true, err
+		}
+		if err := ctx.deadLetter(&FailedItem{s.Name, data, err, attempt}); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+	for _, result := range results {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case out <- result:
+		}
+	}
+	return false, nil
+}
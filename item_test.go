@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+)
+
+func processItems(step *Step, values ...interface{}) []interface{} {
+	in := make(chan interface{})
+	go func() {
+		for _, v := range values {
+			in <- v
+		}
+		close(in)
+	}()
+	results := []interface{}{}
+	for v := range step.Process(&Context{context.Background(), nil}, in) {
+		results = append(results, v)
+	}
+	return results
+}
+
+func sortedInts(values []interface{}) []int {
+	ints := []int{}
+	for _, v := range values {
+		ints = append(ints, v.(int))
+	}
+	sort.Ints(ints)
+	return ints
+}
+
+func TestMapStep(t *testing.T) {
+	step := NewMapStep("doubler", 3, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data.(int) * 2, nil
+	})
+	results := sortedInts(processItems(step, 1, 2, 3, 4))
+	expected := []int{2, 4, 6, 8}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, found %d", len(expected), len(results))
+	}
+	for i, v := range results {
+		if v != expected[i] {
+			t.Fatalf("expected %d, found %d", expected[i], v)
+		}
+	}
+	if err := step.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+}
+
+func TestFilterStep(t *testing.T) {
+	step := NewFilterStep("evens", 2, func(ctx *Context, data interface{}) (bool, error) {
+		return data.(int)%2 == 0, nil
+	})
+	results := sortedInts(processItems(step, 1, 2, 3, 4, 5, 6))
+	if len(results) != 3 || results[0] != 2 || results[1] != 4 || results[2] != 6 {
+		t.Fatalf("expected [2 4 6], found %v", results)
+	}
+	step.Wait()
+}
+
+func TestFlatMapStep(t *testing.T) {
+	step := NewFlatMapStep("repeater", 1, func(ctx *Context, data interface{}) ([]interface{}, error) {
+		n := data.(int)
+		results := []interface{}{}
+		for i := 0; i < n; i++ {
+			results = append(results, n)
+		}
+		return results, nil
+	})
+	results := processItems(step, 1, 2, 3)
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, found %d", len(results))
+	}
+	step.Wait()
+}
+
+var errBadItem = errors.New("bad item")
+
+func TestMapStepError(t *testing.T) {
+	step := NewMapStep("failer", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		if data.(int) == 2 {
+			return nil, errBadItem
+		}
+		return data, nil
+	})
+	in := make(chan interface{}, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+	for range step.Process(&Context{context.Background(), nil}, in) {
+	}
+	if err := step.Wait(); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected %v, found %v", errBadItem, err)
+	}
+}
+
+func TestMapStepCancel(t *testing.T) {
+	step := NewMapStep("identity", 2, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data, nil
+	})
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := step.Process(&Context{ctx, nil}, in)
+	in <- 1
+	<-out
+	cancel()
+	for range out {
+	}
+	step.Wait()
+}
+
+func TestMapStepPanic(t *testing.T) {
+	step := NewMapStep("panicker", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		if data.(int) == 2 {
+			panic(errBadItem)
+		}
+		return data, nil
+	})
+	step.ErrorMode = ErrorModeDeadLetter
+	p := NewPipeline("panics")
+	in := make(chan interface{}, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+	results := []interface{}{}
+	for v := range step.Process(&Context{context.Background(), p}, in) {
+		results = append(results, v)
+	}
+	if err := step.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, found %d", len(results))
+	}
+	item := <-p.DeadLetters()
+	var panicErr *PanicError
+	if item.Input != 2 || !errors.As(item.Err, &panicErr) || !errors.Is(item.Err, errBadItem) {
+		t.Fatalf("unexpected failed item %+v", item)
+	}
+}
@@ -0,0 +1,277 @@
+package pipeline
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrJoinBranches is the error for a join stage that doesn't have exactly two
+// branches, such as when more steps are added to it.
+var ErrJoinBranches = errors.New("pipeline: join stage requires exactly two branches")
+
+// MaxJoinStoreSize limits the number of items a join stage holds on each side
+// while they wait for a match.
+const MaxJoinStoreSize = 1000
+
+// JoinMode determines which items a join stage emits.
+type JoinMode int
+
+const (
+	// JoinInner emits only items that matched an item from the other branch
+	JoinInner JoinMode = iota
+	// JoinLeft also emits left items that never matched, with a nil Right
+	JoinLeft
+	// JoinOuter also emits items from either branch that never matched, with
+	// a nil counterpart
+	JoinOuter
+)
+
+// Joined is an item emitted by a join stage.
+type Joined struct {
+	// Key both items share
+	Key interface{}
+	// Left is the item from the left branch, nil if unmatched
+	Left interface{}
+	// Right is the item from the right branch, nil if unmatched
+	Right interface{}
+}
+
+// NewJoinStage creates a new stage that sends each item to both the left and
+// right branches and emits a *Joined for every pair of their outputs with
+// equal keys. In a Graph with two stages connected to it, the stage connected
+// first feeds only the left branch and the other only the right branch.
+// Keys must be comparable. Items wait for a match until they are
+// older than the stage's JoinTTL, the store for their branch is full or the
+// input ends, and are then emitted unmatched depending on the mode.
+func NewJoinStage(name string, mode JoinMode, left *Step, leftKey KeyFn, right *Step, rightKey KeyFn) *Stage {
+	s := newStage(name, true, left, right)
+	s.join = &joiner{mode: mode, keys: [2]KeyFn{leftKey, rightKey}}
+	return s
+}
+
+// joinEntry is an item waiting in the join store.
+type joinEntry struct {
+	key     interface{}
+	data    interface{}
+	at      time.Time
+	matched bool
+}
+
+// joinSide is the store of items from one branch.
+type joinSide struct {
+	keys  map[interface{}][]*joinEntry
+	queue []*joinEntry
+	size  int
+}
+
+// joiner correlates the outputs of the two branches of a join stage.
+type joiner struct {
+	mode  JoinMode
+	keys  [2]KeyFn
+	sides [2]*joinSide
+	out   chan interface{}
+	held  *atomic.Int64
+}
+
+// processJoin starts both branches of a join stage and joins their output.
+// The left branch reads in and the right branch reads right, or if right is
+// nil every item from in is sent to both branches.
+func (s *Stage) processJoin(ctx *Context, in, right <-chan interface{}) chan interface{} {
+	if len(s.steps) != 2 {
+		return s.failStart(ErrJoinBranches, in, right)
+	}
+	ins := [2]<-chan interface{}{in, right}
+	if right == nil {
+		ins = s.broadcast(ctx, in)
+	}
+	outs := [2]chan interface{}{}
+	for i, step := range s.steps {
+		s.updateStatus(step.Name, StatusStepStarted)
+		outs[i] = step.Process(ctx, ins[i])
+	}
+	return s.startJoin(ctx, outs)
+}
+
+// broadcast sends every item from in to both branches of a join stage.
+func (s *Stage) broadcast(ctx *Context, in <-chan interface{}) [2]<-chan interface{} {
+	branches := [2]chan interface{}{make(chan interface{}), make(chan interface{})}
+	s.Go(func() error {
+		defer func() {
+			for _, branch := range branches {
+				close(branch)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				for _, branch := range branches {
+					select {
+					case <-ctx.Done():
+						return nil
+					case branch <- data:
+					}
+				}
+			}
+		}
+	})
+	return [2]<-chan interface{}{branches[0], branches[1]}
+}
+
+// startJoin joins the output of both branches of a join stage.
+func (s *Stage) startJoin(ctx *Context, outs [2]chan interface{}) chan interface{} {
+	j := s.join
+	j.out = make(chan interface{})
+	j.held = &s.held
+	size := s.JoinStoreSize
+	if size <= 0 {
+		size = MaxJoinStoreSize
+	}
+	for i := range j.sides {
+		j.sides[i] = &joinSide{keys: map[interface{}][]*joinEntry{}, size: size}
+	}
+	s.Go(func() error {
+		defer close(j.out)
+		var expired <-chan time.Time
+		if s.JoinTTL > 0 {
+			ticker := time.NewTicker(s.JoinTTL)
+			defer ticker.Stop()
+			expired = ticker.C
+		}
+		for outs[0] != nil || outs[1] != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case now := <-expired:
+				if !j.expire(ctx, now.Add(-s.JoinTTL)) {
+					return nil
+				}
+			case data, ok := <-outs[0]:
+				if !ok {
+					outs[0] = nil
+				} else if !s.joinItem(ctx, 0, data) {
+					return nil
+				}
+			case data, ok := <-outs[1]:
+				if !ok {
+					outs[1] = nil
+				} else if !s.joinItem(ctx, 1, data) {
+					return nil
+				}
+			}
+		}
+		j.expire(ctx, time.Time{})
+		return nil
+	})
+	s.trackSteps(ctx, nil)
+	return j.out
+}
+
+// joinItem adds an item from one side to the join. Items whose key function
+// panics or returns a key that isn't comparable are dropped and fail the
+// stage. It returns false if the context was done.
+func (s *Stage) joinItem(ctx *Context, side int, data interface{}) bool {
+	key, err := s.joinKey(ctx, side, data)
+	if err != nil {
+		s.fail("join", err)
+		return true
+	}
+	return s.join.add(ctx, side, key, data)
+}
+
+// joinKey returns the key of an item from one side, recovering a panic as a
+// *PanicError.
+func (s *Stage) joinKey(ctx *Context, side int, data interface{}) (key interface{}, err error) {
+	defer s.recover(ctx, &err)
+	key = s.join.keys[side](data)
+	// Keys are stored in maps, which panics if they aren't comparable
+	_ = map[interface{}]struct{}{key: {}}
+	return key, nil
+}
+
+// add stores an item from one side and emits a pair for every stored item
+// from the other side with the same key. It returns false if the context
+// was done.
+func (j *joiner) add(ctx *Context, side int, key, data interface{}) bool {
+	e := &joinEntry{key: key, data: data, at: time.Now()}
+	store, other := j.sides[side], j.sides[1-side]
+	for _, match := range other.keys[e.key] {
+		e.matched, match.matched = true, true
+		pair := &Joined{Key: e.key}
+		if side == 0 {
+			pair.Left, pair.Right = data, match.data
+		} else {
+			pair.Left, pair.Right = match.data, data
+		}
+		if !j.send(ctx, pair) {
+			return false
+		}
+	}
+	store.keys[e.key] = append(store.keys[e.key], e)
+	store.queue = append(store.queue, e)
+	j.held.Add(1)
+	for len(store.queue) > store.size {
+		if !j.evict(ctx, side) {
+			return false
+		}
+	}
+	return true
+}
+
+// expire evicts items that arrived before the cutoff on both sides, or every
+// item if the cutoff is zero. It returns false if the context was done.
+func (j *joiner) expire(ctx *Context, cutoff time.Time) bool {
+	for side, store := range j.sides {
+		for len(store.queue) > 0 && (cutoff.IsZero() || store.queue[0].at.Before(cutoff)) {
+			if !j.evict(ctx, side) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// evict removes the oldest item from a side, emitting it if it never matched
+// and the mode includes that side.
+func (j *joiner) evict(ctx *Context, side int) bool {
+	store := j.sides[side]
+	e := store.queue[0]
+	store.queue = store.queue[1:]
+	j.held.Add(-1)
+	entries := store.keys[e.key]
+	for i, entry := range entries {
+		if entry == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(store.keys, e.key)
+	} else {
+		store.keys[e.key] = entries
+	}
+	if e.matched || j.mode == JoinInner || (j.mode == JoinLeft && side == 1) {
+		return true
+	}
+	pair := &Joined{Key: e.key}
+	if side == 0 {
+		pair.Left = e.data
+	} else {
+		pair.Right = e.data
+	}
+	return j.send(ctx, pair)
+}
+
+func (j *joiner) send(ctx *Context, pair *Joined) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case j.out <- pair:
+		return true
+	}
+}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+type record struct {
+	kind string
+	id   int
+}
+
+func recordsOf(kind string) *Step {
+	return NewFilterStep(kind, 1, func(ctx *Context, data interface{}) (bool, error) {
+		return data.(*record).kind == kind, nil
+	})
+}
+
+func recordID(data interface{}) interface{} {
+	return data.(*record).id
+}
+
+func newOrderJoin(mode JoinMode) *Stage {
+	return NewJoinStage("orders", mode, recordsOf("order"), recordID, recordsOf("payment"), recordID)
+}
+
+// joinedIDs summarizes joined pairs as their left and right ids, 0 if missing.
+func joinedIDs(results []interface{}) [][2]int {
+	ids := [][2]int{}
+	for _, data := range results {
+		pair := data.(*Joined)
+		id := [2]int{}
+		if pair.Left != nil {
+			id[0] = pair.Left.(*record).id
+		}
+		if pair.Right != nil {
+			id[1] = pair.Right.(*record).id
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i][0]*10+ids[i][1] < ids[j][0]*10+ids[j][1]
+	})
+	return ids
+}
+
+func runJoin(t *testing.T, mode JoinMode, expected ...[2]int) {
+	t.Helper()
+	p := NewPipeline("join", newOrderJoin(mode))
+	results, err := p.Run(nil,
+		&record{"order", 1}, &record{"order", 2}, &record{"order", 3},
+		&record{"payment", 2}, &record{"payment", 3}, &record{"payment", 4},
+	)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	ids := joinedIDs(results)
+	if len(ids) != len(expected) {
+		t.Fatalf("expected %v, found %v", expected, ids)
+	}
+	for i, id := range ids {
+		if id != expected[i] {
+			t.Fatalf("expected %v, found %v", expected, ids)
+		}
+	}
+}
+
+func TestInnerJoin(t *testing.T) {
+	runJoin(t, JoinInner, [2]int{2, 2}, [2]int{3, 3})
+}
+
+func TestLeftJoin(t *testing.T) {
+	runJoin(t, JoinLeft, [2]int{1, 0}, [2]int{2, 2}, [2]int{3, 3})
+}
+
+func TestOuterJoin(t *testing.T) {
+	runJoin(t, JoinOuter, [2]int{0, 4}, [2]int{1, 0}, [2]int{2, 2}, [2]int{3, 3})
+}
+
+func TestJoinStoreSize(t *testing.T) {
+	stage := newOrderJoin(JoinLeft)
+	stage.JoinStoreSize = 2
+	in := make(chan interface{})
+	out := stage.Process(&Context{context.Background(), nil}, in)
+	for i := 1; i <= 3; i++ {
+		in <- &record{"order", i}
+	}
+	select {
+	case data := <-out:
+		if pair := data.(*Joined); pair.Left.(*record).id != 1 || pair.Right != nil {
+			t.Fatalf("expected oldest order to be evicted unmatched, found %v", pair)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected full store to evict the oldest order")
+	}
+	close(in)
+	for range out {
+	}
+	stage.Wait()
+}
+
+func TestJoinTTL(t *testing.T) {
+	stage := newOrderJoin(JoinOuter)
+	stage.JoinTTL = 10 * time.Millisecond
+	in := make(chan interface{})
+	out := stage.Process(&Context{context.Background(), nil}, in)
+	in <- &record{"payment", 7}
+	select {
+	case data := <-out:
+		if pair := data.(*Joined); pair.Left != nil || pair.Right.(*record).id != 7 {
+			t.Fatalf("expected payment to expire unmatched, found %v", pair)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected payment to expire")
+	}
+	close(in)
+	for range out {
+	}
+	stage.Wait()
+}
+
+func TestGraphJoin(t *testing.T) {
+	g := NewGraph("join")
+	g.AddNode(NewStage("orders", recordsOf("order")))
+	g.AddNode(NewStage("payments", recordsOf("payment")))
+	g.AddNode(NewJoinStage("join", JoinOuter, NewMapStep("left", 1, passMap), recordID, NewMapStep("right", 1, passMap), recordID))
+	// The stage connected first feeds the left branch
+	g.Connect("orders", "join")
+	g.Connect("payments", "join")
+	results, err := g.Run(nil,
+		&record{"order", 1}, &record{"order", 2},
+		&record{"payment", 2}, &record{"payment", 3},
+	)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	for _, data := range results {
+		pair := data.(*Joined)
+		if (pair.Left != nil && pair.Left.(*record).kind != "order") || (pair.Right != nil && pair.Right.(*record).kind != "payment") {
+			t.Fatalf("expected orders on the left and payments on the right, found %v and %v", pair.Left, pair.Right)
+		}
+	}
+	ids := joinedIDs(results)
+	expected := [][2]int{{0, 3}, {1, 0}, {2, 2}}
+	if len(ids) != len(expected) {
+		t.Fatalf("expected %v, found %v", expected, ids)
+	}
+	for i, id := range ids {
+		if id != expected[i] {
+			t.Fatalf("expected %v, found %v", expected, ids)
+		}
+	}
+}
+
+func TestJoinKeyPanic(t *testing.T) {
+	for name, key := range map[string]KeyFn{
+		"panic": func(data interface{}) interface{} {
+			panic("no key")
+		},
+		"not comparable": func(data interface{}) interface{} {
+			return []int{data.(*record).id}
+		},
+	} {
+		stage := NewJoinStage("orders", JoinInner, recordsOf("order"), key, recordsOf("payment"), recordID)
+		_, err := NewPipeline("join", stage).Run(nil, &record{"order", 1}, &record{"payment", 1})
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Step != "orders" {
+			t.Fatalf("%s: expected a panic error from the key, found %v", name, err)
+		}
+	}
+}
+
+func TestJoinStageExtraStep(t *testing.T) {
+	stage := newOrderJoin(JoinInner)
+	stage.AddStep(recordsOf("refund"))
+	_, err := NewPipeline("join", stage).Run(nil, &record{"order", 1})
+	if !errors.Is(err, ErrJoinBranches) {
+		t.Fatalf("expected %v, found %v", ErrJoinBranches, err)
+	}
+}
@@ -0,0 +1,28 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StepMetrics are measurements of a step's processing.
+type StepMetrics struct {
+	// RateLimited is the number of items delayed by a rate limiter
+	RateLimited int
+	// RateLimitWait is the total time items spent waiting on rate limiters
+	RateLimitWait time.Duration
+}
+
+// stepMetrics are the counters behind StepMetrics, updated from any worker.
+type stepMetrics struct {
+	rateLimited   atomic.Int64
+	rateLimitWait atomic.Int64
+}
+
+// Metrics returns the current measurements of the step.
+func (s *Step) Metrics() StepMetrics {
+	return StepMetrics{
+		RateLimited:   int(s.metrics.rateLimited.Load()),
+		RateLimitWait: time.Duration(s.metrics.rateLimitWait.Load()),
+	}
+}
@@ -0,0 +1,37 @@
+package pipeline
+
+// AsStep returns a step that runs the whole pipeline, so it can be reused
+// inside a stage of another pipeline. See NewPipelineStep.
+func (p *Pipeline) AsStep(name string) *Step {
+	return NewPipelineStep(name, p)
+}
+
+// NewPipelineStep creates a new step that runs a pipeline on its input and
+// sends along the pipeline's output. State updates and failed items of the
+// nested pipeline are forwarded to the parent pipeline, with names prefixed
+// by the step name, and its steps count toward the parent's progress.
+func NewPipelineStep(name string, p *Pipeline) *Step {
+	s := newStep(name, false, false, 1, nil)
+	s.nested = p
+	return s
+}
+
+// runNested runs the step's pipeline, sending its output along. The pipeline
+// only reports to the parent for this run, so it can still run on its own.
+func (s *Step) runNested(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+	p := s.nested.acquire()
+	defer p.release()
+	parent, path := p.parent, p.path
+	p.parent, p.path = ctx.pipeline, s.Name
+	defer func() {
+		p.parent, p.path = parent, path
+	}()
+	for data := range p.process(ctx, in) {
+		select {
+		case <-ctx.Done():
+			return p.Wait()
+		case out <- data:
+		}
+	}
+	return p.Wait()
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPipelineStep(t *testing.T) {
+	child := newDoublePipeline()
+	inc := NewMapStep("inc", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data.(int) + 1, nil
+	})
+	parent := NewPipeline("parent", NewSerialStage("outer", child.AsStep("nested"), inc))
+	results, err := parent.Run(nil, 1, 2, 3)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if ints := sortedInts(results); len(ints) != 3 || ints[0] != 3 || ints[1] != 5 || ints[2] != 7 {
+		t.Fatalf("expected [3 5 7], found %v", ints)
+	}
+	finished, total, _ := parent.CurrentProgress()
+	if _, childTotal, _ := child.CurrentProgress(); total != childTotal+1 {
+		t.Fatalf("expected %d steps including nested ones, found %d", childTotal+1, total)
+	}
+	if finished != total {
+		t.Fatalf("expected all %d steps to finish, found %d", total, finished)
+	}
+	forwarded := false
+	for len(parent.State()) > 0 {
+		state := <-parent.State()
+		if strings.HasPrefix(state.Name, "nested/") {
+			forwarded = true
+		}
+	}
+	if !forwarded {
+		t.Fatal("expected nested pipeline states to be forwarded with a prefixed name")
+	}
+}
+
+func TestPipelineStepError(t *testing.T) {
+	child := NewPipeline("child", NewSerialStage("failing", failingStep(errBadItem)))
+	parent := NewPipeline("parent", NewSerialStage("outer", NewPipelineStep("nested", child)))
+	if _, err := parent.Run(nil, 1); err == nil {
+		t.Fatal("expected nested pipeline error")
+	}
+}
+
+func TestPipelineStepDeadLetters(t *testing.T) {
+	step := NewMapStep("odd", 1, oddFailer)
+	step.ErrorMode = ErrorModeDeadLetter
+	child := NewPipeline("child", NewSerialStage("odd", step))
+	parent := NewPipeline("parent", NewSerialStage("outer", child.AsStep("nested")))
+	if _, err := parent.Run(nil, 1, 2); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	select {
+	case item := <-parent.DeadLetters():
+		if !strings.HasPrefix(item.Step, "nested/") {
+			t.Fatalf("expected failed item step to be prefixed, found %q", item.Step)
+		}
+	default:
+		t.Fatal("expected failed item to be forwarded to the parent")
+	}
+}
+
+func TestPipelineStepStandalone(t *testing.T) {
+	child := newDoublePipeline()
+	parent := NewPipeline("parent", NewSerialStage("outer", child.AsStep("nested")))
+	if _, err := parent.Run(nil, 1); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	for len(parent.State()) > 0 {
+		<-parent.State()
+	}
+	if _, err := child.Run(nil, 1); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(parent.State()) > 0 {
+		t.Fatalf("expected a standalone run not to report to the parent, found %v", <-parent.State())
+	}
+	if len(child.State()) == 0 {
+		t.Fatal("expected a standalone run to report its own states")
+	}
+}
@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+// sequenced is an input item tagged with its position in the input stream.
+type sequenced struct {
+	seq  int
+	data interface{}
+}
+
+// result holds all output produced by a worker for a single input item.
+type result struct {
+	seq  int
+	outs []interface{}
+	ack  chan struct{}
+}
+
+// reorder dispatches input across workers and re-sequences their output so
+// it leaves in the same order the input arrived.
+//
+// A worker is considered done with an item once it takes the next one or
+// exits, so step functions must emit all output for an item before reading
+// the next one. Map, filter and flat map steps also signal when they are done
+// with an item so its output leaves without waiting for more input.
+type reorder struct {
+	tomb.Tomb
+	ins      []chan interface{}
+	outs     []chan interface{}
+	dones    []chan struct{}
+	out      chan interface{}
+	done     <-chan struct{}
+	dispatch chan sequenced
+	results  chan *result
+	size     int
+	held     *atomic.Int64
+}
+
+// newReorder creates per worker channels for num workers and starts
+// sequencing input from in to out. At most size completed items are held
+// while waiting on a slower item, counted in held.
+func newReorder(done <-chan struct{}, in <-chan interface{}, out chan interface{}, num int, size int, held *atomic.Int64) *reorder {
+	r := &reorder{}
+	r.ins = make([]chan interface{}, num)
+	r.outs = make([]chan interface{}, num)
+	r.dones = make([]chan struct{}, num)
+	r.out = out
+	r.done = done
+	r.dispatch = make(chan sequenced)
+	r.results = make(chan *result)
+	r.size = size
+	r.held = held
+	r.Go(func() error {
+		return r.sequence(in)
+	})
+	wg := &sync.WaitGroup{}
+	wg.Add(num)
+	for i := 0; i < num; i++ {
+		r.ins[i] = make(chan interface{})
+		r.outs[i] = make(chan interface{})
+		r.dones[i] = make(chan struct{})
+		win, wout, wdone := r.ins[i], r.outs[i], r.dones[i]
+		r.Go(func() error {
+			defer wg.Done()
+			return r.proxy(win, wout, wdone)
+		})
+	}
+	r.Go(func() error {
+		wg.Wait()
+		close(r.results)
+		return nil
+	})
+	r.Go(r.collect)
+	return r
+}
+
+// sequence tags input items and hands them out to whichever worker is free.
+func (r *reorder) sequence(in <-chan interface{}) error {
+	defer close(r.dispatch)
+	for seq := 0; ; seq++ {
+		select {
+		case <-r.Dying():
+			return nil
+		case data, ok := <-in:
+			if !ok {
+				return nil
+			}
+			select {
+			case <-r.Dying():
+				return nil
+			case r.dispatch <- sequenced{seq, data}:
+			}
+		}
+	}
+}
+
+// proxy feeds a single worker and attributes its output to the item it is
+// currently processing until the worker is done with it.
+func (r *reorder) proxy(in chan interface{}, out chan interface{}, done chan struct{}) error {
+	ack := make(chan struct{}, 1)
+	var current *result
+	var next sequenced
+	hasNext := false
+	dispatch := r.dispatch
+	for {
+		var send chan interface{}
+		var recv <-chan sequenced
+		if hasNext {
+			send = in
+		} else if dispatch != nil {
+			recv = dispatch
+		}
+		select {
+		case <-r.Dying():
+			return nil
+		case item, ok := <-recv:
+			if !ok {
+				dispatch = nil
+				close(in)
+				continue
+			}
+			next, hasNext = item, true
+		case send <- next.data:
+			if !r.finish(current) {
+				return nil
+			}
+			current = &result{seq: next.seq, ack: ack}
+			hasNext = false
+		case <-done:
+			if !r.finish(current) {
+				return nil
+			}
+			current = nil
+		case data, ok := <-out:
+			if !ok {
+				// The worker is done, anything it didn't take is dropped
+				if r.finish(current) && hasNext {
+					r.finish(&result{seq: next.seq, ack: ack})
+				}
+				return nil
+			}
+			if current == nil {
+				// Output before any input isn't tied to an item
+				if !r.finish(&result{seq: -1, outs: []interface{}{data}, ack: ack}) {
+					return nil
+				}
+				continue
+			}
+			current.outs = append(current.outs, data)
+		}
+	}
+}
+
+// finish hands a completed item to the collector and waits until it has
+// room for it.
+func (r *reorder) finish(res *result) bool {
+	if res == nil {
+		return true
+	}
+	select {
+	case <-r.Dying():
+		return false
+	case r.results <- res:
+	}
+	select {
+	case <-r.Dying():
+		return false
+	case <-res.ack:
+		return true
+	}
+}
+
+// collect buffers completed items and emits them in sequence.
+func (r *reorder) collect() error {
+	defer r.Kill(nil)
+	pending := map[int]*result{}
+	parked := []*result{}
+	next := 0
+	for {
+		select {
+		case <-r.Dying():
+			return nil
+		case res, ok := <-r.results:
+			if !ok {
+				// Flush anything left over in order, skipping gaps
+				seqs := []int{}
+				for seq := range pending {
+					seqs = append(seqs, seq)
+				}
+				sort.Ints(seqs)
+				for _, seq := range seqs {
+					if !r.emit(pending[seq].outs) {
+						return nil
+					}
+					r.held.Add(-1)
+				}
+				return nil
+			}
+			if res.seq < 0 {
+				res.ack <- struct{}{}
+				if !r.emit(res.outs) {
+					return nil
+				}
+				continue
+			}
+			r.held.Add(1)
+			if res.seq == next || len(pending) < r.size {
+				pending[res.seq] = res
+				res.ack <- struct{}{}
+			} else {
+				// Hold off the worker until there's room
+				parked = append(parked, res)
+			}
+			for {
+				if res, ok := pending[next]; ok {
+					delete(pending, next)
+					if !r.emit(res.outs) {
+						return nil
+					}
+					r.held.Add(-1)
+					next++
+					continue
+				}
+				admitted := false
+				for i, res := range parked {
+					if res.seq == next || len(pending) < r.size {
+						pending[res.seq] = res
+						res.ack <- struct{}{}
+						parked = append(parked[:i], parked[i+1:]...)
+						admitted = true
+						break
+					}
+				}
+				if !admitted {
+					break
+				}
+			}
+		}
+	}
+}
+
+// itemDoneKey is the context key for the channel a worker of an ordered step
+// signals on once it's done with an item.
+type itemDoneKey struct{}
+
+// itemDone tells an ordered step that the worker running with this context
+// sent all output for its current item.
+func (c *Context) itemDone() {
+	if done, ok := c.Value(itemDoneKey{}).(chan struct{}); ok {
+		select {
+		case <-c.Done():
+		case done <- struct{}{}:
+		}
+	}
+}
+
+func (r *reorder) emit(outs []interface{}) bool {
+	for _, data := range outs {
+		select {
+		case <-r.Dying():
+			return false
+		case <-r.done:
+			return false
+		case r.out <- data:
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedMapStep(t *testing.T) {
+	step := NewMapStep("jitter", 8, func(ctx *Context, data interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		return data, nil
+	})
+	step.Ordered = true
+	values := []interface{}{}
+	for i := 0; i < 500; i++ {
+		values = append(values, i)
+	}
+	results := processItems(step, values...)
+	if len(results) != len(values) {
+		t.Fatalf("expected %d results, found %d", len(values), len(results))
+	}
+	for i, v := range results {
+		if v.(int) != i {
+			t.Fatalf("expected %d at position %d, found %d", i, i, v)
+		}
+	}
+	step.Wait()
+}
+
+func TestOrderedWorkerStep(t *testing.T) {
+	// Each input produces several outputs which must stay grouped in order
+	step := NewOrderedWorkerStep("repeater", 5, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		for n := range in {
+			time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+			for i := 0; i < 3; i++ {
+				out <- n
+			}
+		}
+		return nil
+	})
+	values := []interface{}{}
+	for i := 0; i < 100; i++ {
+		values = append(values, i)
+	}
+	results := processItems(step, values...)
+	if len(results) != 300 {
+		t.Fatalf("expected 300 results, found %d", len(results))
+	}
+	for i, v := range results {
+		if v.(int) != i/3 {
+			t.Fatalf("expected %d at position %d, found %d", i/3, i, v)
+		}
+	}
+	step.Wait()
+}
+
+func TestReorderBounded(t *testing.T) {
+	in := make(chan interface{})
+	out := make(chan interface{})
+	r := newReorder(context.Background().Done(), in, out, 4, 1, &atomic.Int64{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(win <-chan interface{}, wout chan interface{}) {
+			defer wg.Done()
+			defer close(wout)
+			for n := range win {
+				if n.(int) == 0 {
+					// Head of line item is slow
+					time.Sleep(10 * time.Millisecond)
+				}
+				wout <- n
+			}
+		}(r.ins[i], r.outs[i])
+	}
+	go func() {
+		for i := 0; i < 50; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	for i := 0; i < 50; i++ {
+		if n := (<-out).(int); n != i {
+			t.Fatalf("expected %d, found %d", i, n)
+		}
+	}
+	wg.Wait()
+	r.Wait()
+}
+
+func TestOrderedMapStepStreaming(t *testing.T) {
+	step := NewMapStep("double", 2, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data.(int) * 2, nil
+	})
+	step.Ordered = true
+	in := make(chan interface{})
+	out := step.Process(&Context{context.Background(), nil}, in)
+	for i := 1; i <= 3; i++ {
+		in <- i
+		// Each item leaves as soon as it's done without waiting for more input
+		select {
+		case data := <-out:
+			if data != i*2 {
+				t.Fatalf("expected %d, found %v", i*2, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected item %d to leave before the next one arrived", i)
+		}
+	}
+	close(in)
+	for range out {
+	}
+	step.Wait()
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// KeyFn returns the key of an item.
+type KeyFn func(interface{}) interface{}
+
+// Partitioner picks which of n workers handles a key.
+type Partitioner interface {
+	Partition(key interface{}, n int) int
+}
+
+// PartitionerFunc adapts a function to a Partitioner.
+type PartitionerFunc func(key interface{}, n int) int
+
+// Partition calls f(key, n).
+func (f PartitionerFunc) Partition(key interface{}, n int) int {
+	return f(key, n)
+}
+
+// HashPartitioner spreads keys across workers by the hash of their string form.
+var HashPartitioner Partitioner = PartitionerFunc(func(key interface{}, n int) int {
+	h := fnv.New32a()
+	fmt.Fprint(h, key)
+	return int(h.Sum32() % uint32(n))
+})
+
+// RangePartitioner assigns keys to workers by ascending upper bounds. Keys
+// less than bounds[0] go to the first worker, keys less than bounds[1] to the
+// second and so on, with the rest going to the last worker, even if there are
+// fewer workers than bounds. Keys of another type go to the first worker.
+func RangePartitioner[K cmp.Ordered](bounds ...K) Partitioner {
+	return PartitionerFunc(func(key interface{}, n int) int {
+		k, ok := key.(K)
+		if !ok {
+			return 0
+		}
+		i := sort.Search(len(bounds), func(i int) bool {
+			return k < bounds[i]
+		})
+		if i >= n {
+			i = n - 1
+		}
+		return i
+	})
+}
+
+// NewPartitionedStep creates a new worker based step that sends all items
+// with the same key to the same worker.
+func NewPartitionedStep(name string, workerCount int, keyFn KeyFn, step func(*Context, <-chan interface{}, chan interface{}) error) *Step {
+	s := newStep(name, false, false, workerCount, step)
+	s.KeyFn = keyFn
+	return s
+}
+
+// pick returns the worker index for an item, killing the step with a
+// *PanicError and returning -1 if the key function or partitioner panicked.
+func (s *Step) pick(ctx *Context, data interface{}) (i int) {
+	var err error
+	defer func() {
+		if err != nil {
+			s.Kill(err)
+			i = -1
+		}
+	}()
+	defer s.recover(ctx, &err)
+	return s.partition(data)
+}
+
+// partition returns the worker index for an item.
+func (s *Step) partition(data interface{}) int {
+	partitioner := s.Partitioner
+	if partitioner == nil {
+		partitioner = HashPartitioner
+	}
+	i := partitioner.Partition(s.KeyFn(data), s.WorkerCount) % s.WorkerCount
+	if i < 0 {
+		i += s.WorkerCount
+	}
+	return i
+}
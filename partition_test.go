@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+type event struct {
+	customer int
+	seq      int
+	worker   int64
+}
+
+func TestPartitionedStep(t *testing.T) {
+	var workers atomic.Int64
+	step := NewPartitionedStep("events", 4, func(data interface{}) interface{} {
+		return data.(*event).customer
+	}, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		worker := workers.Add(1)
+		for data := range in {
+			e := data.(*event)
+			e.worker = worker
+			out <- e
+		}
+		return nil
+	})
+	values := []interface{}{}
+	for seq := 0; seq < 20; seq++ {
+		for customer := 0; customer < 10; customer++ {
+			values = append(values, &event{customer: customer, seq: seq})
+		}
+	}
+	results := processItems(step, values...)
+	if len(results) != len(values) {
+		t.Fatalf("expected %d results, found %d", len(values), len(results))
+	}
+	lastSeq := map[int]int{}
+	worker := map[int]int64{}
+	for _, data := range results {
+		e := data.(*event)
+		if seq, ok := lastSeq[e.customer]; ok && e.seq != seq+1 {
+			t.Fatalf("customer %d events out of order, %d followed %d", e.customer, e.seq, seq)
+		}
+		lastSeq[e.customer] = e.seq
+		if w, ok := worker[e.customer]; ok && w != e.worker {
+			t.Fatalf("customer %d handled by workers %d and %d", e.customer, w, e.worker)
+		}
+		worker[e.customer] = e.worker
+	}
+	step.Wait()
+}
+
+func TestHashPartitioner(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		p := HashPartitioner.Partition(i, 4)
+		if p != HashPartitioner.Partition(i, 4) {
+			t.Fatalf("expected key %d to always use the same partition", i)
+		}
+		counts[p]++
+	}
+	for i, count := range counts {
+		if count == 0 {
+			t.Fatalf("expected keys in partition %d", i)
+		}
+	}
+}
+
+func TestRangePartitioner(t *testing.T) {
+	partitioner := RangePartitioner("g", "n", "t")
+	tests := []struct {
+		key      interface{}
+		expected int
+	}{
+		{"apple", 0},
+		{"grape", 1},
+		{"melon", 1},
+		{"pear", 2},
+		{"zucchini", 3},
+		{42, 0},
+	}
+	for _, test := range tests {
+		if actual := partitioner.Partition(test.key, 4); actual != test.expected {
+			t.Errorf("incorrect partition for %v, expected: %d actual: %d", test.key, test.expected, actual)
+		}
+	}
+}
+
+func TestRangePartitionerFewerWorkers(t *testing.T) {
+	partitioner := RangePartitioner(10, 20)
+	for key, expected := range map[int]int{5: 0, 15: 1, 25: 1} {
+		if actual := partitioner.Partition(key, 2); actual != expected {
+			t.Errorf("incorrect partition for %d, expected: %d actual: %d", key, expected, actual)
+		}
+	}
+}
+
+func TestPartitionPanic(t *testing.T) {
+	step := NewPartitionedStep("panics", 2, func(data interface{}) interface{} {
+		panic("no key")
+	}, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		for data := range in {
+			out <- data
+		}
+		return nil
+	})
+	p := NewPipeline("panics", NewSerialStage("stage", step))
+	_, err := p.Run(nil, 1, 2, 3)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Step != "panics" {
+		t.Fatalf("expected a panic error from the key function, found %v", err)
+	}
+}
+
+func TestCustomPartitioner(t *testing.T) {
+	step := NewPartitionedStep("custom", 3, func(data interface{}) interface{} {
+		return data
+	}, nil)
+	// Out of range partitions wrap around
+	step.Partitioner = PartitionerFunc(func(key interface{}, n int) int {
+		return key.(int) - n
+	})
+	for i, expected := range []int{0, 1, 2, 0} {
+		if actual := step.partition(i); actual != expected {
+			t.Errorf("incorrect partition for %d, expected: %d actual: %d", i, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// gate holds up callers while it's paused.
+type gate struct {
+	mu sync.Mutex
+	// paused is closed when the gate resumes, nil while it's open
+	paused chan struct{}
+}
+
+// pause closes the gate, returning false if it was already paused.
+func (g *gate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused != nil {
+		return false
+	}
+	g.paused = make(chan struct{})
+	return true
+}
+
+// resume opens the gate, returning false if it wasn't paused.
+func (g *gate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == nil {
+		return false
+	}
+	close(g.paused)
+	g.paused = nil
+	return true
+}
+
+func (g *gate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused != nil
+}
+
+// wait blocks while the gate is paused, returning false if the context is
+// done first.
+func (g *gate) wait(ctx context.Context) bool {
+	for {
+		g.mu.Lock()
+		paused := g.paused
+		g.mu.Unlock()
+		if paused == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-paused:
+		}
+	}
+}
+
+// Pause stops every step of the pipeline from taking more input, keeping
+// their goroutines and buffers alive. Items already taken are finished and
+// wait for the next step to resume. Reported with StatusPaused.
+func (p *Pipeline) Pause() {
+	if p.gate.pause() {
+		p.updateStatus(p.Name, StatusPaused)
+	}
+}
+
+// Resume continues processing after Pause. Reported with StatusResumed.
+func (p *Pipeline) Resume() {
+	if p.gate.resume() {
+		p.updateStatus(p.Name, StatusResumed)
+	}
+}
+
+// Paused reports whether the pipeline is paused.
+func (p *Pipeline) Paused() bool {
+	return p.gate.isPaused()
+}
+
+// wait blocks while the pipeline or any pipeline it's nested in is paused.
+func (p *Pipeline) wait(ctx context.Context) bool {
+	for ; p != nil; p = p.parent {
+		if !p.gate.wait(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// Pause stops every step of the stage from taking more input, keeping
+// their goroutines and buffers alive. Reported with StatusPaused.
+func (s *Stage) Pause() {
+	if s.gate.pause() && s.ctx != nil {
+		s.updateStatus(s.Name, StatusPaused)
+	}
+}
+
+// Resume continues processing after Pause. Reported with StatusResumed.
+func (s *Stage) Resume() {
+	if s.gate.resume() && s.ctx != nil {
+		s.updateStatus(s.Name, StatusResumed)
+	}
+}
+
+// Paused reports whether the stage is paused.
+func (s *Stage) Paused() bool {
+	return s.gate.isPaused()
+}
+
+// wait blocks while the step's stage or pipeline is paused, returning false
+// if the context is done first.
+func (s *Step) wait(ctx *Context) bool {
+	if s.stage != nil && !s.stage.gate.wait(ctx) {
+		return false
+	}
+	return ctx.pipeline.wait(ctx)
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingMap(count *atomic.Int64) func(*Context, interface{}) (interface{}, error) {
+	return func(ctx *Context, data interface{}) (interface{}, error) {
+		count.Add(1)
+		return data, nil
+	}
+}
+
+func TestPipelinePause(t *testing.T) {
+	var handled atomic.Int64
+	p := NewPipeline("pause", NewSerialStage("stage", NewMapStep("count", 2, countingMap(&handled))))
+	p.Pause()
+	if !p.Paused() {
+		t.Fatal("expected pipeline to be paused")
+	}
+	done := make(chan []interface{})
+	go func() {
+		results, _ := p.Run(nil, 1, 2, 3, 4, 5)
+		done <- results
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := handled.Load(); n != 0 {
+		t.Fatalf("expected no items handled while paused, found %d", n)
+	}
+	p.Resume()
+	if results := <-done; len(results) != 5 {
+		t.Fatalf("expected 5 results after resuming, found %d", len(results))
+	}
+	paused, resumed := false, false
+	for len(p.State()) > 0 {
+		switch (<-p.State()).Status {
+		case StatusPaused:
+			paused = true
+		case StatusResumed:
+			resumed = true
+		}
+	}
+	if !paused || !resumed {
+		t.Fatalf("expected paused and resumed states, found %v and %v", paused, resumed)
+	}
+}
+
+func TestStagePause(t *testing.T) {
+	var first, second atomic.Int64
+	stage := NewSerialStage("second", NewMapStep("second", 1, countingMap(&second)))
+	p := NewPipeline("pause", NewSerialStage("first", NewMapStep("first", 1, countingMap(&first))), stage)
+	stage.Pause()
+	done := make(chan []interface{})
+	go func() {
+		results, _ := p.Run(nil, 1, 2, 3, 4, 5)
+		done <- results
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := second.Load(); n != 0 {
+		t.Fatalf("expected no items handled by the paused stage, found %d", n)
+	}
+	if n := first.Load(); n == 0 || n == 5 {
+		t.Fatalf("expected the first stage to back up behind the paused stage, found %d items", n)
+	}
+	stage.Resume()
+	if results := <-done; len(results) != 5 {
+		t.Fatalf("expected 5 results after resuming, found %d", len(results))
+	}
+}
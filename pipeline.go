@@ -2,7 +2,9 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/tomb.v2"
@@ -13,14 +15,44 @@ type Pipeline struct {
 	tomb.Tomb
 	// Name is the name of the step
 	Name string
+	// MaxDeadLetters is the number of failed items allowed before the pipeline
+	// fails with ErrDeadLetterThreshold.
+	// Defaults to 0, no limit
+	MaxDeadLetters int
+	// CollectErrors indicates whether the pipeline should keep processing when
+	// a step fails and report every error, as opposed to stopping on the first.
+	// Defaults to false
+	CollectErrors bool
+	// RateLimit limits how many items per second every step in the pipeline
+	// takes from its input, sharing one budget across all of them.
+	// Defaults to nil, no limit
+	RateLimit *RateLimiter
+	// OnStart is called before the pipeline starts its stages.
+	// Defaults to nil
+	OnStart StartFn
+	// OnFinish is called once the pipeline's stages are done.
+	// Defaults to nil
+	OnFinish FinishFn
 	// stages list of all stages in pipeline
 	stages []*Stage
+	// edges connect stages when the pipeline is a graph, nil for a linear pipeline
+	edges map[*Stage][]*Stage
+	// inbound lists the stages connected to each stage in the order they were connected
+	inbound map[*Stage][]*Stage
+	// fans used to connect stages in a graph
+	fans []*fan
 	// stateCh is a channel that will send status changes
 	stateCh chan *State
+	// deadLetterCh is a channel that will send failed items
+	deadLetterCh chan *FailedItem
+	// deadLetterCount for number of failed items
+	deadLetterCount atomic.Int64
 	// altProgressCh is a channel to listen for progress updates
 	altProgressCh chan float32
 	// altProgressPct returns the last sent progress update value
 	altProgressPct float32
+	// unitMu guards the units of work, which are counted from many workers
+	unitMu sync.Mutex
 	// unitTotal for total units of work
 	unitTotal int
 	// unitCount for current units of work completed
@@ -29,6 +61,26 @@ type Pipeline struct {
 	startTime time.Time
 	// endTime of pipeline processing
 	endTime time.Time
+	// errs collects errors from the stages
+	errs errorList
+	// parent is the pipeline this one runs in as a step, if any
+	parent *Pipeline
+	// path is the name of the step this pipeline runs as in its parent
+	path string
+	// runID identifies the current run
+	runID string
+	// runMu guards starting a run
+	runMu sync.Mutex
+	// inUse indicates the current run is still used by whoever started it
+	inUse bool
+	// gate pauses the steps of the pipeline
+	gate gate
+	// draining is closed to stop taking input
+	draining chan struct{}
+	// drainOnce ensures draining is only closed once
+	drainOnce *sync.Once
+	// held is the number of items taken from the input but not yet passed on
+	held atomic.Int64
 }
 
 // NewPipeline creates a new pipeline with the provided stages.
@@ -38,6 +90,7 @@ func NewPipeline(name string, stages ...*Stage) *Pipeline {
 	p.stages = stages
 	p.stateCh = make(chan *State, 100)
 	p.altProgressCh = make(chan float32, 100)
+	p.deadLetterCh = make(chan *FailedItem, 100)
 	return p
 }
 
@@ -59,40 +112,81 @@ func (p *Pipeline) AltProgress() <-chan float32 {
 // CurrentProgress returns the current progress percent of the pipeline
 // by measuring the number of steps.
 func (p *Pipeline) CurrentProgress() (int, int, float32) {
-	var stepCount float32
-	var finishedCount float32
+	finishedCount, stepCount := p.stepProgress()
+	return finishedCount, stepCount, float32(finishedCount) / float32(stepCount)
+}
+
+// stepProgress counts the finished and total steps, including the steps of
+// nested pipelines.
+func (p *Pipeline) stepProgress() (int, int) {
+	var finishedCount, stepCount int
 	for _, stage := range p.stages {
-		stepCount += float32(len(stage.steps))
 		for _, step := range stage.steps {
+			if step.nested != nil {
+				finished, count := step.nested.stepProgress()
+				finishedCount += finished
+				stepCount += count
+				continue
+			}
+			stepCount++
 			if !step.Alive() {
 				finishedCount++
 			}
 		}
 	}
-	return int(finishedCount), int(stepCount), finishedCount / stepCount
+	return finishedCount, stepCount
 }
 
 // CurrentAltProgress returns the current alternate progress of the pipeline
 // by measuring the units of work completed.
 func (p *Pipeline) CurrentAltProgress() (int, int, float32) {
+	p.unitMu.Lock()
+	defer p.unitMu.Unlock()
 	return p.unitCount, p.unitTotal, float32(p.unitCount) / float32(p.unitTotal)
 }
 
 // Total sets the unit total used for alternate progress updates.
 func (p *Pipeline) Total(value int) {
+	p.unitMu.Lock()
+	defer p.unitMu.Unlock()
 	p.unitTotal = value
 }
 
 // Inc increments unit count used for alternate progress updates.
 func (p *Pipeline) Inc() {
+	p.Add(1)
+}
+
+// Add increases the unit count by n used for alternate progress updates,
+// such as when a step completes a batch of units at once.
+func (p *Pipeline) Add(n int) {
 	// Calculate progress and determine if an update is needed to be sent
-	p.unitCount++
-	_, _, currentProgress := p.CurrentAltProgress()
+	p.unitMu.Lock()
+	defer p.unitMu.Unlock()
+	p.unitCount += n
+	currentProgress := float32(p.unitCount) / float32(p.unitTotal)
 
 	// Updates are sent per 1% increase
 	if (currentProgress-p.altProgressPct) > 0.01 || p.unitCount == p.unitTotal {
 		p.altProgressPct = currentProgress
-		p.altProgressCh <- currentProgress
+		p.sendAltProgress(currentProgress)
+	}
+}
+
+// sendAltProgress sends a progress update without blocking. If no one is
+// listening and the channel is full the oldest update is dropped, so the
+// latest progress is always sent.
+func (p *Pipeline) sendAltProgress(progress float32) {
+	for {
+		select {
+		case p.altProgressCh <- progress:
+			return
+		default:
+		}
+		select {
+		case <-p.altProgressCh:
+		default:
+		}
 	}
 }
 
@@ -105,14 +199,44 @@ func (p *Pipeline) ElapsedTime() time.Duration {
 	return t.Sub(p.startTime)
 }
 
-// Process executes the pipeline.
+// Process executes the pipeline. Processing a pipeline that is still running
+// starts a concurrent run on a clone of it, which sends to the same State,
+// DeadLetters and AltProgress channels with its own RunID, while Wait and
+// the progress and timing methods keep following the first run. Use Run,
+// RunFunc or Clone to keep track of concurrent runs.
 func (p *Pipeline) Process(ctx context.Context, in <-chan interface{}) chan interface{} {
+	r := p.acquire()
+	// The run is left to the caller, so it's only in use until it finishes
+	defer r.release()
+	return r.process(ctx, in)
+}
+
+// process executes a run started by acquire.
+func (p *Pipeline) process(ctx context.Context, in <-chan interface{}) chan interface{} {
 	// Process stages serially
 	p.updateStatus(p.Name, StatusPipelineStarted)
 	if ctx == nil {
 		ctx = context.Background()
 	}
 	c := &Context{p.Context(ctx), p}
+	if err := p.start(c); err != nil {
+		return p.failStart(err)
+	}
+	// stop is closed once the stages are done
+	stop := make(chan struct{})
+	if len(p.stages) == 0 {
+		// Nothing to process, so finish at once without reading the input
+		out := make(chan interface{})
+		close(out)
+		p.trackStages(c, stop)
+		return out
+	}
+	in = p.intake(c, in, stop)
+	if p.edges != nil {
+		out := p.processGraph(c, in)
+		p.trackStages(c, stop)
+		return out
+	}
 	var out chan interface{}
 	for _, stage := range p.stages {
 		p.updateStatus(stage.Name, StatusStageStarted)
@@ -122,13 +246,12 @@ func (p *Pipeline) Process(ctx context.Context, in <-chan interface{}) chan inte
 			out = stage.Process(c, out)
 		}
 	}
-	p.trackStages()
+	p.trackStages(c, stop)
 	return out
 }
 
-func (p *Pipeline) trackStages() {
+func (p *Pipeline) trackStages(ctx *Context, stop chan struct{}) {
 	p.Go(func() error {
-		var firstErr error
 		wg := &sync.WaitGroup{}
 		for _, s := range p.stages {
 			wg.Add(1)
@@ -136,18 +259,52 @@ func (p *Pipeline) trackStages() {
 			go func() {
 				defer wg.Done()
 				err := stage.Wait()
-				if firstErr == nil {
-					firstErr = err
+				var stageErr *PipelineError
+				if errors.As(err, &stageErr) {
+					p.errs.add(stageErr.Errors...)
+				} else if err != nil {
+					p.errs.add(&StepError{Stage: stage.Name, Time: time.Now(), Err: err})
 				}
 				p.updateStatus(stage.Name, StatusStageFinished)
 			}()
 		}
 		wg.Wait()
+		close(stop)
+		for _, f := range p.fans {
+			select {
+			case <-f.Dead():
+			case <-ctx.Done():
+				f.Kill(nil)
+				f.Wait()
+			}
+		}
+		p.finish(ctx)
 		p.updateStatus(p.Name, StatusPipelineFinished)
-		return firstErr
+		return p.errs.err(p.Name)
 	})
 }
 
+// failStart stops the pipeline before any of its stages start.
+func (p *Pipeline) failStart(err error) chan interface{} {
+	p.errs.add(&StepError{Step: "start", Time: time.Now(), Err: err})
+	out := make(chan interface{})
+	p.Go(func() error {
+		close(out)
+		p.updateStatus(p.Name, StatusPipelineFinished)
+		return p.errs.err(p.Name)
+	})
+	return out
+}
+
+// fail records an error outside of a step and stops processing unless the
+// pipeline is collecting all errors.
+func (p *Pipeline) fail(err *StepError) {
+	p.errs.add(err)
+	if !p.CollectErrors {
+		p.Kill(nil)
+	}
+}
+
 func (p *Pipeline) updateStatus(name string, status Status) {
 	if status == StatusPipelineStarted {
 		p.startTime = time.Now()
@@ -155,7 +312,33 @@ func (p *Pipeline) updateStatus(name string, status Status) {
 		p.endTime = time.Now()
 	}
 
-	_, _, currentProgress := p.CurrentProgress()
-	_, _, currentAltProgress := p.CurrentAltProgress()
-	p.stateCh <- &State{name, status, currentProgress, currentAltProgress}
+	p.send(&State{Name: name, Status: status}, true)
+}
+
+// notify sends a state update that may be frequent, such as per item events,
+// dropping it rather than blocking if no one is listening.
+func (p *Pipeline) notify(state *State) {
+	p.send(state, false)
+}
+
+// send fills in the progress of a state update and sends it, waiting for
+// room on the channel if wait is set. Nested pipelines forward their updates
+// to the parent, prefixed with the name of the step they run as.
+func (p *Pipeline) send(state *State, wait bool) {
+	if p.parent != nil {
+		state.Name = p.path + "/" + state.Name
+		p.parent.send(state, wait)
+		return
+	}
+	state.RunID = p.runID
+	_, _, state.Progress = p.CurrentProgress()
+	_, _, state.AltProgress = p.CurrentAltProgress()
+	if wait {
+		p.stateCh <- state
+		return
+	}
+	select {
+	case p.stateCh <- state:
+	default:
+	}
 }
@@ -217,3 +217,38 @@ John,Doe,jd
 Jane,Doe,jd
 Foo,Bar,fb
 `
+
+func TestPipelineCompletion(t *testing.T) {
+	identity := func(ctx *Context, data interface{}) (interface{}, error) {
+		return data, nil
+	}
+	buffered := NewMapStep("buffered", 3, identity)
+	buffered.Buffered = true
+	fanOut := NewMapStep("fan out", 2, identity)
+	fanOut.FanOut = true
+	first := NewSerialStage("serial", NewMapStep("worker", 4, identity), fanOut)
+	second := NewConcurrentStage("concurrent", buffered, NewMapStep("plain", 1, identity))
+	pipeline := NewPipeline("completion", first, second)
+
+	in := make(chan interface{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	// Closing the input is enough for the pipeline to finish on its own
+	count := 0
+	for range pipeline.Process(nil, in) {
+		count++
+	}
+	if err := pipeline.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if count != 200 {
+		t.Fatalf("expected 200 items, but pipeline produced %d", count)
+	}
+	if _, _, progress := pipeline.CurrentProgress(); progress != 1 {
+		t.Fatalf("expected all steps to finish, found progress %f", progress)
+	}
+}
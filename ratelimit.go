@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits how many items per second pass
+// through the steps using it. A limiter can be shared by several steps, even
+// across pipelines, to give them a combined budget.
+type RateLimiter struct {
+	// rate of tokens added per second
+	rate float64
+	// burst is the most tokens the bucket holds
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a new rate limiter allowing perSecond items per
+// second on average and up to burst items at once. A rate of 0 or less
+// disables the limit.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Wait blocks until an item is allowed through or the context is done,
+// returning how long it waited.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	d := l.reserve()
+	if d <= 0 {
+		return 0, nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return time.Since(start), ctx.Err()
+	case <-t.C:
+		return d, nil
+	}
+}
+
+// reserve takes a token and returns how long to wait until it's available.
+func (l *RateLimiter) reserve() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// limiters returns the rate limiters that apply to the step.
+func (s *Step) limiters(ctx *Context) []*RateLimiter {
+	limiters := []*RateLimiter{}
+	if s.RateLimit != nil {
+		limiters = append(limiters, s.RateLimit)
+	}
+	if ctx.pipeline != nil && ctx.pipeline.RateLimit != nil {
+		limiters = append(limiters, ctx.pipeline.RateLimit)
+	}
+	return limiters
+}
+
+// limit waits on each of the limiters in turn, recording the time spent
+// waiting in the step's metrics.
+func (s *Step) limit(ctx *Context, limiters []*RateLimiter) {
+	for _, l := range limiters {
+		waited, err := l.Wait(ctx)
+		if waited > 0 {
+			s.metrics.rateLimited.Add(1)
+			s.metrics.rateLimitWait.Add(int64(waited))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func passMap(ctx *Context, data interface{}) (interface{}, error) {
+	return data, nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, 5)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		if _, err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("expected no error, found %v", err)
+		}
+	}
+	// The burst passes at once, the other 10 items take 10ms each
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("expected limiter to delay items, took %v", d)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	l.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx); err == nil {
+		t.Fatal("expected wait to stop when the context is done")
+	}
+}
+
+func TestStepRateLimit(t *testing.T) {
+	step := NewMapStep("limited", 2, passMap)
+	step.RateLimit = NewRateLimiter(200, 1)
+	start := time.Now()
+	results := processItems(step, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	if len(results) != 10 {
+		t.Fatalf("expected 10 results, found %d", len(results))
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("expected step to be rate limited, took %v", d)
+	}
+	metrics := step.Metrics()
+	if metrics.RateLimited == 0 || metrics.RateLimitWait <= 0 {
+		t.Fatalf("expected rate limit waits in metrics, found %+v", metrics)
+	}
+	step.Wait()
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	first := NewMapStep("first", 1, passMap)
+	second := NewMapStep("second", 1, passMap)
+	p := NewPipeline("limited", NewSerialStage("stage", first, second))
+	p.RateLimit = NewRateLimiter(200, 1)
+	start := time.Now()
+	if _, err := p.Run(nil, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	// Both steps take from the same budget, 20 items at 5ms each
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("expected steps to share the pipeline limit, took %v", d)
+	}
+	if first.Metrics().RateLimitWait <= 0 || second.Metrics().RateLimitWait <= 0 {
+		t.Fatal("expected both steps to wait on the pipeline limit")
+	}
+}
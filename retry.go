@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how per item functions are retried when they fail.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries, zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every retry.
+	// Defaults to 2, set to 1 for a constant delay
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, i.e. 0.2
+	// spreads delays by ±20%.
+	// Defaults to 0
+	Jitter float64
+	// Retryable reports whether an error should be retried.
+	// Defaults to retrying all errors
+	Retryable func(error) bool
+}
+
+// shouldRetry reports whether another attempt should be made after the
+// given attempt failed with err.
+func (p *RetryPolicy) shouldRetry(err error, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// delay returns how long to wait after the given attempt failed.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(p.Backoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// wait blocks for the delay after the given attempt or until the context is done.
+func (p *RetryPolicy) wait(ctx *Context, attempt int) bool {
+	t := time.NewTimer(p.delay(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
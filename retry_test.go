@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	errFatal := errors.New("fatal")
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Retryable: func(err error) bool {
+			return err != errFatal
+		},
+	}
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		err      error
+		attempt  int
+		expected bool
+	}{
+		{"nil policy", nil, errTransient, 1, false},
+		{"first attempt", policy, errTransient, 1, true},
+		{"last attempt", policy, errTransient, 3, false},
+		{"not retryable", policy, errFatal, 1, false},
+		{"default retryable", &RetryPolicy{MaxAttempts: 2}, errFatal, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.policy.shouldRetry(test.err, test.attempt); actual != test.expected {
+				t.Errorf("incorrect retry decision, expected: %t actual: %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expected {
+		if actual := policy.delay(i + 1); actual != d*time.Millisecond {
+			t.Errorf("incorrect delay for attempt %d, expected: %s actual: %s", i+1, d*time.Millisecond, actual)
+		}
+	}
+
+	policy = &RetryPolicy{Backoff: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := policy.delay(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("jittered delay out of range, found %s", d)
+		}
+	}
+}
+
+func TestRetryStep(t *testing.T) {
+	attempts := 0
+	step := NewMapStep("flaky", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errTransient
+		}
+		return data, nil
+	})
+	step.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	p := NewPipeline("retry")
+	in := make(chan interface{}, 1)
+	in <- 1
+	close(in)
+	results := []interface{}{}
+	for v := range step.Process(&Context{context.Background(), p}, in) {
+		results = append(results, v)
+	}
+	if err := step.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, found %d", len(results))
+	}
+	for i := 1; i < 3; i++ {
+		state := <-p.State()
+		if state.Status != StatusStepRetried || state.Attempt != i || state.Err != errTransient {
+			t.Fatalf("expected retry state for attempt %d, found %+v", i, state)
+		}
+	}
+}
+
+func TestRetryStepExhausted(t *testing.T) {
+	step := NewMapStep("broken", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return nil, errTransient
+	})
+	step.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	in := make(chan interface{}, 1)
+	in <- 1
+	close(in)
+	for range step.Process(&Context{context.Background(), nil}, in) {
+	}
+	if err := step.Wait(); err != errTransient {
+		t.Fatalf("expected %v, found %v", errTransient, err)
+	}
+}
+
+func TestRetryStepStateFull(t *testing.T) {
+	attempts := 0
+	step := NewMapStep("flaky", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errTransient
+		}
+		return data, nil
+	})
+	step.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	p := NewPipeline("retry")
+	// No one has read the state channel yet
+	for len(p.stateCh) < cap(p.stateCh) {
+		p.stateCh <- &State{}
+	}
+	in := make(chan interface{}, 1)
+	in <- 1
+	close(in)
+	out := step.Process(&Context{context.Background(), p}, in)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range out {
+		}
+	}()
+	// Give the retries time to happen while the channel is full
+	time.Sleep(50 * time.Millisecond)
+	retried := 0
+	for retried < 2 {
+		select {
+		case state := <-p.State():
+			if state.Status == StatusStepRetried {
+				retried++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected every retry to be reported, found %d", retried)
+		}
+	}
+	<-done
+	step.Wait()
+}
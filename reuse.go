@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+// ErrRunning is returned when resetting a pipeline that is still running.
+var ErrRunning = errors.New("pipeline: still running")
+
+// RunID returns the unique id of the pipeline's current or last run, or an
+// empty string if it hasn't run since it was created or reset.
+func (p *Pipeline) RunID() string {
+	return p.runID
+}
+
+// Reset clears the lifecycle, progress, timing and errors of a finished run
+// so the pipeline can process again. Process resets a finished pipeline on
+// its own, so this is only needed to clear the last run ahead of time.
+// Returns ErrRunning if the pipeline is still running.
+func (p *Pipeline) Reset() error {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	if p.running() {
+		return ErrRunning
+	}
+	p.reset()
+	return nil
+}
+
+// Clone returns a new pipeline with the same stages, steps and options that
+// can process concurrently with this one. Step functions and rate limiters
+// are shared, everything about a run is not.
+func (p *Pipeline) Clone() *Pipeline {
+	c := NewPipeline(p.Name)
+	c.MaxDeadLetters = p.MaxDeadLetters
+	c.CollectErrors = p.CollectErrors
+	c.RateLimit = p.RateLimit
+	c.OnStart = p.OnStart
+	c.OnFinish = p.OnFinish
+	_, c.unitTotal, _ = p.CurrentAltProgress()
+	stages := map[*Stage]*Stage{}
+	for _, stage := range p.stages {
+		stages[stage] = stage.clone()
+		c.stages = append(c.stages, stages[stage])
+	}
+	if p.edges != nil {
+		c.edges = map[*Stage][]*Stage{}
+		c.inbound = map[*Stage][]*Stage{}
+		for to, froms := range p.inbound {
+			for _, from := range froms {
+				c.inbound[stages[to]] = append(c.inbound[stages[to]], stages[from])
+			}
+		}
+		for from, nexts := range p.edges {
+			for _, next := range nexts {
+				c.edges[stages[from]] = append(c.edges[stages[from]], stages[next])
+			}
+		}
+	}
+	return c
+}
+
+// acquire starts a new run of the pipeline, resetting the last one if it
+// finished, and returns the pipeline to process it with, which stays in use
+// until release is called. If the pipeline is still running or in use the
+// run is started on a clone that reports to the same channels, so
+// concurrent runs each get their own run state.
+func (p *Pipeline) acquire() *Pipeline {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	if !p.running() {
+		p.begin()
+		p.inUse = true
+		return p
+	}
+	c := p.Clone()
+	c.stateCh = p.stateCh
+	c.deadLetterCh = p.deadLetterCh
+	c.altProgressCh = p.altProgressCh
+	c.begin()
+	c.inUse = true
+	return c
+}
+
+// release lets a pipeline be reset for a new run once its current run
+// finishes, after whoever acquired it is done with it.
+func (p *Pipeline) release() {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	p.inUse = false
+}
+
+// begin starts a new run, resetting the last one.
+func (p *Pipeline) begin() {
+	p.reset()
+	p.runID = newRunID()
+	p.draining = make(chan struct{})
+	p.drainOnce = &sync.Once{}
+}
+
+// running reports whether a run has started and not yet finished, or is
+// still in use by whoever acquired it.
+func (p *Pipeline) running() bool {
+	if p.inUse {
+		return true
+	}
+	if p.runID == "" {
+		return false
+	}
+	select {
+	case <-p.Dead():
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *Pipeline) reset() {
+	p.Tomb = tomb.Tomb{}
+	p.runID = ""
+	p.fans = nil
+	p.held.Store(0)
+	p.deadLetterCount.Store(0)
+	p.unitMu.Lock()
+	p.unitCount = 0
+	p.altProgressPct = 0
+	p.unitMu.Unlock()
+	p.startTime = time.Time{}
+	p.endTime = time.Time{}
+	p.errs.reset()
+	for _, stage := range p.stages {
+		stage.reset()
+	}
+}
+
+// reset clears the last run of a stage and its steps, panicking if the stage
+// is still running.
+func (s *Stage) reset() {
+	if s.ctx == nil {
+		return
+	}
+	select {
+	case <-s.Dead():
+	default:
+		panic(fmt.Sprintf("pipeline: stage %q is still running", s.Name))
+	}
+	s.Tomb = tomb.Tomb{}
+	s.ctx = nil
+	s.errs.reset()
+	s.unrouted.Store(0)
+	s.held.Store(0)
+	for _, step := range s.steps {
+		step.reset()
+	}
+}
+
+func (s *Stage) clone() *Stage {
+	c := newStage(s.Name, s.Concurrent)
+	c.JoinTTL = s.JoinTTL
+	c.JoinStoreSize = s.JoinStoreSize
+	c.OnStart = s.OnStart
+	c.OnFinish = s.OnFinish
+	c.route = s.route
+	if s.routes != nil {
+		c.routes = map[*Step]string{}
+	}
+	for _, step := range s.steps {
+		cs := step.clone()
+		if route, ok := s.routes[step]; ok {
+			c.routes[cs] = route
+		}
+		c.steps = append(c.steps, cs)
+	}
+	if s.join != nil {
+		c.join = &joiner{mode: s.join.mode, keys: s.join.keys}
+	}
+	return c
+}
+
+// reset clears the last run of a step, panicking if the step is still running.
+func (s *Step) reset() {
+	if s.wg == nil {
+		return
+	}
+	select {
+	case <-s.Dead():
+	default:
+		panic(fmt.Sprintf("pipeline: step %q is still running", s.Name))
+	}
+	s.Tomb = tomb.Tomb{}
+	s.wg = nil
+	s.f = nil
+	s.buffers = nil
+	s.held.Store(0)
+	s.metrics.rateLimited.Store(0)
+	s.metrics.rateLimitWait.Store(0)
+	if s.nested != nil {
+		s.nested.reset()
+	}
+}
+
+func (s *Step) clone() *Step {
+	c := newStep(s.Name, s.Buffered, s.FanOut, s.WorkerCount, s.fn)
+	c.Distribution = s.Distribution
+	c.Ordered = s.Ordered
+	c.Retry = s.Retry
+	c.ErrorMode = s.ErrorMode
+	c.KeyFn = s.KeyFn
+	c.Partitioner = s.Partitioner
+	c.RateLimit = s.RateLimit
+	c.Timeout = s.Timeout
+	c.ItemTimeout = s.ItemTimeout
+	c.OnStart = s.OnStart
+	c.OnFinish = s.OnFinish
+	c.Setup = s.Setup
+	c.Teardown = s.Teardown
+	c.Autoscale = s.Autoscale
+	c.each = s.each
+	if s.nested != nil {
+		c.nested = s.nested.Clone()
+	}
+	return c
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,188 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineRunTwice(t *testing.T) {
+	p := newDoublePipeline()
+	runIDs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		results, err := p.Run(context.Background(), 1, 2, 3)
+		if err != nil {
+			t.Fatalf("run %d expected no error, found %v", i, err)
+		}
+		if len(results) != 3 || results[0] != 2 || results[1] != 4 || results[2] != 6 {
+			t.Fatalf("run %d expected [2 4 6], found %v", i, results)
+		}
+		if finished, total, _ := p.CurrentProgress(); finished != total {
+			t.Fatalf("run %d expected all %d steps to finish, found %d", i, total, finished)
+		}
+		runIDs[p.RunID()] = true
+	}
+	if len(runIDs) != 2 {
+		t.Fatalf("expected a new run id for every run, found %v", runIDs)
+	}
+	if err := p.Reset(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if finished, _, _ := p.CurrentProgress(); finished != 0 || p.RunID() != "" {
+		t.Fatalf("expected reset to clear the last run, found %d steps finished", finished)
+	}
+}
+
+func TestPipelineRunErrorsReset(t *testing.T) {
+	p := NewPipeline("odd", NewStage("stage", NewMapStep("evens", 1, oddFailer)))
+	if _, err := p.Run(nil, 1); err == nil {
+		t.Fatal("expected first run to fail")
+	}
+	if _, err := p.Run(nil, 2); err != nil {
+		t.Fatalf("expected errors of the last run to be cleared, found %v", err)
+	}
+}
+
+func TestPipelineRunID(t *testing.T) {
+	p := newDoublePipeline()
+	p.Run(nil, 1)
+	for len(p.State()) > 0 {
+		if state := <-p.State(); state.RunID != p.RunID() {
+			t.Fatalf("expected state for run %q, found %q", p.RunID(), state.RunID)
+		}
+	}
+}
+
+func TestPipelineStillRunning(t *testing.T) {
+	p := newDoublePipeline()
+	in := make(chan interface{})
+	out := p.Process(nil, in)
+	if err := p.Reset(); err != ErrRunning {
+		t.Fatalf("expected ErrRunning, found %v", err)
+	}
+	runID := p.RunID()
+	results, err := p.Run(nil, 1, 2, 3)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 3 || results[0] != 2 || results[1] != 4 || results[2] != 6 {
+		t.Fatalf("expected [2 4 6] while running, found %v", results)
+	}
+	if p.RunID() != runID {
+		t.Fatalf("expected the running pipeline to keep run %q, found %q", runID, p.RunID())
+	}
+	close(in)
+	for range out {
+	}
+	p.Wait()
+}
+
+func TestPipelineRunConcurrently(t *testing.T) {
+	p := newDoublePipeline()
+	wg := &sync.WaitGroup{}
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results, err := p.Run(nil, n)
+			if err != nil {
+				t.Errorf("expected no error, found %v", err)
+			}
+			if len(results) != 1 || results[0] != n*2 {
+				t.Errorf("expected [%d], found %v", n*2, results)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPipelineClone(t *testing.T) {
+	g := NewGraph("diamond")
+	g.AddNode(multiplyStage("a", 1))
+	g.AddNode(multiplyStage("b", 2))
+	g.AddNode(multiplyStage("c", 3))
+	g.AddNode(NewRouterStage("d", routeByParity, map[string]*Step{"even": tagStep("even"), DefaultRoute: tagStep("odd")}))
+	g.Connect("a", "b")
+	g.Connect("a", "c")
+	g.Connect("b", "d")
+	g.Connect("c", "d")
+	wg := &sync.WaitGroup{}
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results, err := g.Clone().Run(nil, n)
+			if err != nil {
+				t.Errorf("expected no error, found %v", err)
+			}
+			if len(results) != 2 {
+				t.Errorf("expected 2 results for %d, found %v", n, results)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPipelineRunNestedConcurrently(t *testing.T) {
+	p := NewPipeline("parent", NewSerialStage("outer", newDoublePipeline().AsStep("nested")))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Concurrent runs share the state channel
+		for {
+			select {
+			case <-done:
+				return
+			case <-p.State():
+			}
+		}
+	}()
+	wg := &sync.WaitGroup{}
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				results, err := p.Run(nil, n)
+				if err != nil {
+					t.Errorf("expected no error, found %v", err)
+				}
+				if len(results) != 1 || results[0] != n*2 {
+					t.Errorf("expected [%d], found %v", n*2, results)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPipelineRunAltProgressUnread(t *testing.T) {
+	step := NewMapStep("count", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		ctx.Inc()
+		return data, nil
+	})
+	p := NewPipeline("progress", NewStage("stage", step))
+	p.Total(100)
+	values := make([]interface{}, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// No one reads the alternate progress of these runs
+		for i := 0; i < 3; i++ {
+			p.Run(nil, values...)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected runs to finish without anyone reading progress")
+	}
+	var last float32
+	for len(p.AltProgress()) > 0 {
+		last = <-p.AltProgress()
+	}
+	if last != 1 {
+		t.Fatalf("expected the final progress update to be kept, found %v", last)
+	}
+}
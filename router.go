@@ -0,0 +1,99 @@
+package pipeline
+
+import "sort"
+
+// DefaultRoute is the route of the branch that receives items whose route
+// has no branch of its own.
+const DefaultRoute = "default"
+
+// RouteFn returns the route of the branch an item should be sent to.
+type RouteFn func(*Context, interface{}) string
+
+// NewRouterStage creates a new stage that sends each item to exactly one of
+// its branches based on the route returned by fn. Items whose route has no
+// branch go to the DefaultRoute branch if there is one, otherwise they are
+// dropped and counted as unrouted. The output of all branches is merged.
+func NewRouterStage(name string, fn RouteFn, branches map[string]*Step) *Stage {
+	routes := make([]string, 0, len(branches))
+	for route := range branches {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	s := newStage(name, true)
+	s.route = fn
+	s.routes = map[*Step]string{}
+	for _, route := range routes {
+		s.steps = append(s.steps, branches[route])
+		s.routes[branches[route]] = route
+	}
+	return s
+}
+
+// Unrouted returns the number of items a router stage has dropped because no
+// branch matched their route.
+func (s *Stage) Unrouted() int {
+	return int(s.unrouted.Load())
+}
+
+// processRoutes starts a branch for every step and dispatches input to them.
+func (s *Stage) processRoutes(ctx *Context, in <-chan interface{}) chan interface{} {
+	branches := make(map[string]chan interface{}, len(s.steps))
+	ins := make([]chan interface{}, 0, len(s.steps))
+	for _, step := range s.steps {
+		route, ok := s.routes[step]
+		if !ok {
+			// Steps added later are routed by name
+			route = step.Name
+		}
+		branch := make(chan interface{})
+		branches[route] = branch
+		s.updateStatus(step.Name, StatusStepStarted)
+		ins = append(ins, step.Process(ctx, branch))
+	}
+	s.Go(func() error {
+		defer func() {
+			for _, branch := range branches {
+				close(branch)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				route, err := s.routeOf(ctx, data)
+				if err != nil {
+					// The item is dropped, processing stops unless collecting errors
+					s.fail("route", err)
+					continue
+				}
+				branch, ok := branches[route]
+				if !ok {
+					branch, ok = branches[DefaultRoute]
+				}
+				if !ok {
+					s.unrouted.Add(1)
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case branch <- data:
+				}
+			}
+		}
+	})
+	f := fanIn(ins...)
+	s.trackSteps(ctx, f)
+	return f.out
+}
+
+// routeOf returns the route of an item, recovering a panic in the route
+// function as a *PanicError.
+func (s *Stage) routeOf(ctx *Context, data interface{}) (route string, err error) {
+	defer s.recover(ctx, &err)
+	return s.route(ctx, data), nil
+}
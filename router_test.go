@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func tagStep(tag string) *Step {
+	return NewMapStep(tag, 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return tag, nil
+	})
+}
+
+func routeByParity(ctx *Context, data interface{}) string {
+	n := data.(int)
+	switch {
+	case n < 0:
+		return "negative"
+	case n%2 == 0:
+		return "even"
+	default:
+		return "odd"
+	}
+}
+
+func processRouter(stage *Stage, values ...interface{}) map[interface{}]int {
+	in := make(chan interface{})
+	go func() {
+		for _, v := range values {
+			in <- v
+		}
+		close(in)
+	}()
+	counts := map[interface{}]int{}
+	for v := range stage.Process(&Context{context.Background(), nil}, in) {
+		counts[v]++
+	}
+	return counts
+}
+
+func TestRouterStage(t *testing.T) {
+	stage := NewRouterStage("router", routeByParity, map[string]*Step{
+		"even": tagStep("even"),
+		"odd":  tagStep("odd"),
+	})
+	counts := processRouter(stage, 1, 2, 3, 4, 5, -1, -2)
+	if err := stage.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if counts["even"] != 2 || counts["odd"] != 3 {
+		t.Fatalf("expected 2 even and 3 odd, found %v", counts)
+	}
+	if n := stage.Unrouted(); n != 2 {
+		t.Fatalf("expected 2 unrouted items, found %d", n)
+	}
+}
+
+func TestRouterStageDefault(t *testing.T) {
+	stage := NewRouterStage("router", routeByParity, map[string]*Step{
+		"even":       tagStep("even"),
+		DefaultRoute: tagStep("other"),
+	})
+	counts := processRouter(stage, 1, 2, 3, 4, 5, -1, -2)
+	stage.Wait()
+	if counts["even"] != 2 || counts["other"] != 5 {
+		t.Fatalf("expected 2 even and 5 other, found %v", counts)
+	}
+	if n := stage.Unrouted(); n != 0 {
+		t.Fatalf("expected no unrouted items, found %d", n)
+	}
+}
+
+func TestRouterStagePanic(t *testing.T) {
+	stage := NewRouterStage("router", func(ctx *Context, data interface{}) string {
+		if data.(int) < 0 {
+			panic("negative")
+		}
+		return routeByParity(ctx, data)
+	}, map[string]*Step{
+		"even": tagStep("even"),
+		"odd":  tagStep("odd"),
+	})
+	p := NewPipeline("router", stage)
+	_, err := p.Run(nil, 1, 2, -1, 3)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Step != "router" {
+		t.Fatalf("expected a panic error from the route function, found %v", err)
+	}
+}
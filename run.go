@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// SourceFn produces input for a pipeline run. It should return once all
+// input is sent or the context is done.
+type SourceFn func(ctx context.Context, in chan<- interface{}) error
+
+// SinkFn consumes a single item of pipeline output.
+type SinkFn func(data interface{}) error
+
+// Run processes inputs through the pipeline and blocks until it completes,
+// returning all of the output and any errors. If ctx is done first the output
+// so far is returned with ctx's error.
+func (p *Pipeline) Run(ctx context.Context, inputs ...interface{}) ([]interface{}, error) {
+	results := []interface{}{}
+	err := p.RunFunc(ctx, func(ctx context.Context, in chan<- interface{}) error {
+		for _, input := range inputs {
+			select {
+			case <-ctx.Done():
+				return nil
+			case in <- input:
+			}
+		}
+		return nil
+	}, func(data interface{}) error {
+		results = append(results, data)
+		return nil
+	})
+	return results, err
+}
+
+// RunFunc processes input from source through the pipeline, passing output
+// to sink, and blocks until it completes. The input channel is closed once
+// source returns. Errors from source and sink are reported with the rest of
+// the pipeline's errors. If ctx is done before the output is drained, ctx's
+// error is returned unless the pipeline failed.
+func (p *Pipeline) RunFunc(ctx context.Context, source SourceFn, sink SinkFn) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// Run on a clone of the pipeline if it's still running
+	p = p.acquire()
+	defer p.release()
+	in := make(chan interface{})
+	out := p.process(ctx, in)
+	sourceDone := make(chan struct{})
+	go func() {
+		defer close(sourceDone)
+		defer close(in)
+		if err := source(p.Context(ctx), in); err != nil {
+			p.fail(&StepError{Step: "source", Time: time.Now(), Err: err})
+		}
+	}()
+	sinking := true
+	for data := range out {
+		if !sinking {
+			continue
+		}
+		if err := sink(data); err != nil {
+			p.fail(&StepError{Step: "sink", Time: time.Now(), Err: err})
+			sinking = p.CollectErrors
+		}
+	}
+	// The output may have been cut short by ctx, which isn't a success
+	ctxErr := ctx.Err()
+	// The output is closed so anything left running has nowhere to send to
+	p.Kill(nil)
+	err := p.Wait()
+	<-sourceDone
+	if runErr := p.errs.err(p.Name); runErr != nil {
+		return runErr
+	}
+	if err != nil {
+		return err
+	}
+	return ctxErr
+}
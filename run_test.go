@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newDoublePipeline() *Pipeline {
+	step := NewMapStep("double", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return data.(int) * 2, nil
+	})
+	return NewPipeline("double", NewStage("stage", step))
+}
+
+func TestRun(t *testing.T) {
+	results, err := newDoublePipeline().Run(context.Background(), 1, 2, 3)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 3 || results[0] != 2 || results[1] != 4 || results[2] != 6 {
+		t.Fatalf("expected [2 4 6], found %v", results)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	step := NewMapStep("failer", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return nil, errBadItem
+	})
+	p := NewPipeline("failing", NewStage("stage", step))
+	if _, err := p.Run(context.Background(), 1, 2, 3); !errors.Is(err, errBadItem) {
+		t.Fatalf("expected %v, found %v", errBadItem, err)
+	}
+}
+
+func TestRunFunc(t *testing.T) {
+	total := 0
+	err := newDoublePipeline().RunFunc(nil, func(ctx context.Context, in chan<- interface{}) error {
+		for i := 1; i <= 100; i++ {
+			in <- i
+		}
+		return nil
+	}, func(data interface{}) error {
+		total += data.(int)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if total != 10100 {
+		t.Fatalf("expected total of 10100, found %d", total)
+	}
+}
+
+func TestRunFuncSinkError(t *testing.T) {
+	errSink := errors.New("sink failed")
+	err := newDoublePipeline().RunFunc(nil, func(ctx context.Context, in chan<- interface{}) error {
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return nil
+			case in <- i:
+			}
+		}
+	}, func(data interface{}) error {
+		return errSink
+	})
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "sink" || stepErr.Err != errSink {
+		t.Fatalf("expected sink error, found %v", err)
+	}
+}
+
+func TestRunFuncSourceError(t *testing.T) {
+	errSource := errors.New("source failed")
+	err := newDoublePipeline().RunFunc(nil, func(ctx context.Context, in chan<- interface{}) error {
+		in <- 1
+		return errSource
+	}, func(data interface{}) error {
+		return nil
+	})
+	if !errors.Is(err, errSource) {
+		t.Fatalf("expected %v, found %v", errSource, err)
+	}
+}
+
+func TestRunEmpty(t *testing.T) {
+	for _, p := range []*Pipeline{NewPipeline("empty"), NewGraph("empty").Pipeline} {
+		results, err := p.Run(nil, 1, 2, 3)
+		if err != nil {
+			t.Fatalf("expected no error, found %v", err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no results, found %v", results)
+		}
+		if err := p.RunFunc(nil, func(ctx context.Context, in chan<- interface{}) error {
+			select {
+			case <-ctx.Done():
+			case in <- 1:
+			}
+			return nil
+		}, func(data interface{}) error {
+			return nil
+		}); err != nil {
+			t.Fatalf("expected no error, found %v", err)
+		}
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	p := NewPipeline("slow", NewStage("stage", NewMapStep("slow", 1, slowMap)))
+	ctx, cancel := context.WithCancel(context.Background())
+	values := make([]interface{}, 100)
+	for i := range values {
+		values[i] = i
+	}
+	time.AfterFunc(20*time.Millisecond, cancel)
+	results, err := p.Run(ctx, values...)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to be cancelled, found %v with %d results", err, len(results))
+	}
+	if len(results) == len(values) {
+		t.Fatal("expected the run to stop early")
+	}
+}
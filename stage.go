@@ -2,7 +2,10 @@ package pipeline
 
 import (
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	tomb "gopkg.in/tomb.v2"
 )
@@ -14,10 +17,37 @@ type Stage struct {
 	Name string
 	// Concurrent determines whether to process this stage serially or not.
 	Concurrent bool
+	// JoinTTL is how long a join stage keeps an item waiting for a match.
+	// Defaults to 0, items wait until the store is full or the input ends
+	JoinTTL time.Duration
+	// JoinStoreSize limits the number of items a join stage keeps waiting for
+	// a match from each branch, evicting the oldest when full.
+	// Defaults to MaxJoinStoreSize
+	JoinStoreSize int
+	// OnStart is called before the stage starts its steps.
+	// Defaults to nil
+	OnStart StartFn
+	// OnFinish is called once the stage's steps are done.
+	// Defaults to nil
+	OnFinish FinishFn
 	// steps are the actual steps to run for this stage
 	steps []*Step
 	// ctx is the context for this stage
 	ctx *Context
+	// errs collects errors from the steps
+	errs errorList
+	// route picks the branch for each item in a router stage
+	route RouteFn
+	// routes of each step in a router stage
+	routes map[*Step]string
+	// unrouted counts items dropped by a router stage
+	unrouted atomic.Int64
+	// join correlates the branches of a join stage
+	join *joiner
+	// held is the number of items waiting in the store of a join stage
+	held atomic.Int64
+	// gate pauses the steps of the stage
+	gate gate
 }
 
 func newStage(name string, concurrent bool, steps ...*Step) *Stage {
@@ -61,24 +91,44 @@ func newStageFunc(name string, concurrent bool, stepFns ...StepFn) *Stage {
 	return newStage(name, concurrent, steps...)
 }
 
-// AddStep adds a step to the stage
+// AddStep adds a step to the stage. Join stages fail with ErrJoinBranches if
+// they don't have exactly two steps.
 func (s *Stage) AddStep(step *Step) {
 	s.steps = append(s.steps, step)
 }
 
 // Process executes this stage.
 func (s *Stage) Process(ctx *Context, in <-chan interface{}) chan interface{} {
+	return s.process(ctx, in, nil)
+}
+
+// process executes this stage, feeding the right branch of a join stage
+// from its own input if right is not nil.
+func (s *Stage) process(ctx *Context, in, right <-chan interface{}) chan interface{} {
+	s.reset()
 	s.ctx = ctx
+	for _, step := range s.steps {
+		step.stage = s
+	}
 	c := &Context{s.Context(ctx), ctx.pipeline}
+	if err := s.start(c); err != nil {
+		return s.failStart(err, in, right)
+	}
+	if s.route != nil {
+		return s.processRoutes(c, in)
+	}
+	if s.join != nil {
+		return s.processJoin(c, in, right)
+	}
 	if s.Concurrent {
 		// Process steps concurrently
-		ins := make([]chan interface{}, len(s.steps))
+		ins := make([]chan interface{}, 0, len(s.steps))
 		for _, step := range s.steps {
 			s.updateStatus(step.Name, StatusStepStarted)
 			ins = append(ins, step.Process(c, in))
 		}
 		f := fanIn(ins...)
-		s.trackSteps(f)
+		s.trackSteps(c, f)
 		return f.out
 	}
 
@@ -94,35 +144,82 @@ func (s *Stage) Process(ctx *Context, in <-chan interface{}) chan interface{} {
 			out = step.Process(c, out)
 		}
 	}
-	s.trackSteps(nil)
+	s.trackSteps(c, nil)
 	return out
 }
 
-func (s *Stage) trackSteps(f *fan) {
+func (s *Stage) trackSteps(ctx *Context, f *fan) {
 	s.Go(func() error {
-		var firstErr error
 		wg := &sync.WaitGroup{}
 		for _, st := range s.steps {
 			wg.Add(1)
 			step := st
 			go func() {
 				defer wg.Done()
-				err := step.Wait()
-				if firstErr == nil {
-					firstErr = err
+				// Steps start dying as soon as one of their workers fails
+				<-step.Dying()
+				if err := step.Err(); err != nil {
+					s.fail(step.Name, err)
 				}
+				step.Wait()
 				s.updateStatus(step.Name, StatusStepFinished)
 			}()
 		}
 		wg.Wait()
 		if f != nil {
-			f.Kill(nil)
-			f.Wait()
+			// Let the fan drain what the steps sent unless processing stopped
+			select {
+			case <-f.Dead():
+			case <-ctx.Done():
+				f.Kill(nil)
+				f.Wait()
+			}
 		}
-		return firstErr
+		return s.finish(ctx)
 	})
 }
 
+// recover converts a panic in a function called by the stage itself into a
+// *PanicError and reports it on the state channel. It must be deferred.
+func (s *Stage) recover(ctx *Context, err *error) {
+	if r := recover(); r != nil {
+		panicErr := &PanicError{s.Name, r, debug.Stack()}
+		ctx.report(&State{Name: s.Name, Status: StatusStepPanicked, Err: panicErr})
+		*err = panicErr
+	}
+}
+
+// failStart stops the stage before any of its steps start.
+func (s *Stage) failStart(err error, ins ...<-chan interface{}) chan interface{} {
+	s.errs.add(&StepError{s.Name, "start", time.Now(), err})
+	if s.ctx.pipeline != nil && !s.ctx.pipeline.CollectErrors {
+		s.ctx.pipeline.Kill(nil)
+	}
+	out := make(chan interface{})
+	s.Go(func() error {
+		close(out)
+		return s.errs.err(s.Name)
+	})
+	for _, in := range ins {
+		if in != nil {
+			go discard(s.ctx, in)
+		}
+	}
+	return out
+}
+
+// fail records a step error and stops processing unless the pipeline is
+// collecting all errors.
+func (s *Stage) fail(step string, err error) {
+	s.errs.add(&StepError{s.Name, step, time.Now(), err})
+	if s.ctx.pipeline == nil {
+		s.Kill(nil)
+	} else if !s.ctx.pipeline.CollectErrors {
+		s.Kill(nil)
+		s.ctx.pipeline.Kill(nil)
+	}
+}
+
 func (s *Stage) updateStatus(name string, status Status) {
 	if s.ctx.pipeline != nil {
 		s.ctx.pipeline.updateStatus(name, status)
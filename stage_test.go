@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 )
 
@@ -110,3 +111,84 @@ func TestSerialStage(t *testing.T) {
 	cancel()
 	stage.Wait()
 }
+
+func TestConcurrentStageNil(t *testing.T) {
+	var nilCalls, fanOutCalls, identityCalls atomic.Int64
+	emitNil := func(calls *atomic.Int64) MapFn {
+		return func(ctx *Context, data interface{}) (interface{}, error) {
+			calls.Add(1)
+			return nil, nil
+		}
+	}
+	identity := func(ctx *Context, data interface{}) (interface{}, error) {
+		identityCalls.Add(1)
+		return data, nil
+	}
+	fanOut := NewMapStep("fan out nil", 2, emitNil(&fanOutCalls))
+	fanOut.FanOut = true
+	stage := NewConcurrentStage("nils", NewMapStep("nil", 1, emitNil(&nilCalls)), fanOut, NewMapStep("identity", 1, identity))
+	in := make(chan interface{})
+	go func() {
+		for i := 0; i < 30; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	nils, ints := 0, 0
+	for data := range stage.Process(&Context{context.Background(), nil}, in) {
+		if data == nil {
+			nils++
+		} else {
+			ints++
+		}
+	}
+	if err := stage.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	// Every input is handled by one of the steps, the fan out step's two
+	// workers both handle each of its items
+	fanOutItems := fanOutCalls.Load() / 2
+	if fanOutCalls.Load()%2 != 0 || nilCalls.Load()+fanOutItems+identityCalls.Load() != 30 {
+		t.Fatalf("expected each of 30 items to be handled by one step, found %d, %d and %d calls", nilCalls.Load(), fanOutCalls.Load(), identityCalls.Load())
+	}
+	if expected := int(nilCalls.Load() + fanOutCalls.Load()); nils != expected {
+		t.Fatalf("expected %d nils from the nil and fan out steps, found %d", expected, nils)
+	}
+	if expected := int(identityCalls.Load()); ints != expected {
+		t.Fatalf("expected %d ints from the identity step, found %d", expected, ints)
+	}
+}
+
+func TestSerialStageNil(t *testing.T) {
+	toNil := NewMapStep("to nil", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	fanOut := NewMapStep("fan out", 3, func(ctx *Context, data interface{}) (interface{}, error) {
+		if data != nil {
+			return nil, errBadItem
+		}
+		return data, nil
+	})
+	fanOut.FanOut = true
+	stage := NewSerialStage("nils", toNil, fanOut)
+	in := make(chan interface{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	count := 0
+	for data := range stage.Process(&Context{context.Background(), nil}, in) {
+		if data != nil {
+			t.Fatalf("expected nil, found %v", data)
+		}
+		count++
+	}
+	if err := stage.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if count != 30 {
+		t.Fatalf("expected 30 nil values, found %d", count)
+	}
+}
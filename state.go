@@ -16,6 +16,16 @@ const (
 	StatusStepStarted
 	// StatusStepFinished signal when step has finished
 	StatusStepFinished
+	// StatusStepRetried signal when a step retries a failed item
+	StatusStepRetried
+	// StatusStepPanicked signal when a step recovers from a panic
+	StatusStepPanicked
+	// StatusStepScaled signal when an autoscaling step adds or removes a worker
+	StatusStepScaled
+	// StatusPaused signal when a pipeline or stage is paused
+	StatusPaused
+	// StatusResumed signal when a pipeline or stage is resumed
+	StatusResumed
 )
 
 func (s Status) String() string {
@@ -32,6 +42,16 @@ func (s Status) String() string {
 		return "step started"
 	case StatusStepFinished:
 		return "step finished"
+	case StatusStepRetried:
+		return "step retried"
+	case StatusStepPanicked:
+		return "step panicked"
+	case StatusStepScaled:
+		return "step scaled"
+	case StatusPaused:
+		return "paused"
+	case StatusResumed:
+		return "resumed"
 	default:
 		return ""
 	}
@@ -39,6 +59,8 @@ func (s Status) String() string {
 
 // State type indicating pipeline state
 type State struct {
+	// RunID of the pipeline run reporting the status
+	RunID string
 	// Name of the entity reporting the status
 	Name string
 	// Status of the pipeline
@@ -47,4 +69,10 @@ type State struct {
 	Progress float32
 	// AltProgress percent of the pipeline, used for detailed progress
 	AltProgress float32
+	// Err that caused the status, if any
+	Err error
+	// Attempt number of the item that caused the status, if any
+	Attempt int
+	// Workers is the number of workers a step has after scaling, if any
+	Workers int
 }
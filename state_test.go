@@ -15,6 +15,11 @@ func TestStatusString(t *testing.T) {
 		{"stage finished", StatusStageFinished, "stage finished"},
 		{"step started", StatusStepStarted, "step started"},
 		{"step finished", StatusStepFinished, "step finished"},
+		{"step retried", StatusStepRetried, "step retried"},
+		{"step panicked", StatusStepPanicked, "step panicked"},
+		{"step scaled", StatusStepScaled, "step scaled"},
+		{"paused", StatusPaused, "paused"},
+		{"resumed", StatusResumed, "resumed"},
 	}
 
 	for _, test := range tests {
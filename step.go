@@ -1,7 +1,11 @@
 package pipeline
 
 import (
+	"context"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	tomb "gopkg.in/tomb.v2"
 )
@@ -11,12 +15,17 @@ const (
 	MaxWorkerCount = 20
 	// MaxBufferSize limits the size of the out buffered channel for a step.
 	MaxBufferSize = 10
+	// MaxReorderBufferSize limits the number of completed items an ordered step
+	// holds while waiting on a slower item.
+	MaxReorderBufferSize = 100
 )
 
 // StepFn is the signature of a step function
 type StepFn func(*Context, <-chan interface{}, chan interface{}) error
 
 // Step is the main type for processing in a pipeline.
+// Panics in step functions are recovered and handled like a returned
+// *PanicError, and reported with StatusStepPanicked.
 type Step struct {
 	tomb.Tomb
 	// Name of the step.
@@ -29,15 +38,93 @@ type Step struct {
 	// fan out input channels to each one as opposed to using a single one.
 	// Defaults to false
 	FanOut bool
-	// The number of workers to spawn in go routines to handle this step.
+	// Distribution determines how a fan out step splits input across its
+	// workers.
+	// Defaults to DistributionBroadcast, every worker gets every item
+	Distribution Distribution
+	// Ordered indicates whether output should leave the step in the same order
+	// as the input arrived when using multiple workers. The step function must
+	// emit all output for an item before reading the next one, and its output
+	// for an item leaves once it reads the next one or returns. Map, filter
+	// and flat map steps send their output as soon as they finish an item.
+	// Not supported for fan out steps.
+	// Defaults to false
+	Ordered bool
+	// Retry is the policy for retrying failed items in map, filter and flat map
+	// steps. Each retry is reported with StatusStepRetried.
+	// Defaults to nil, no retries
+	Retry *RetryPolicy
+	// ErrorMode determines what happens to items that fail in map, filter and
+	// flat map steps once retries are exhausted.
+	// Defaults to ErrorModeKill
+	ErrorMode ErrorMode
+	// KeyFn partitions the input across workers so that all items with the
+	// same key are handled by the same worker, in order. Takes precedence over
+	// FanOut and Ordered.
+	// Defaults to nil, workers share the input
+	KeyFn KeyFn
+	// Partitioner picks the worker for each key when using KeyFn.
+	// Defaults to HashPartitioner
+	Partitioner Partitioner
+	// RateLimit limits how many items per second the step takes from its
+	// input, in addition to the pipeline's RateLimit. Time spent waiting is
+	// reported in Metrics.
+	// Defaults to nil, no limit
+	RateLimit *RateLimiter
+	// Timeout bounds how long the step runs. Its context has a deadline and
+	// the step fails with a *TimeoutError once it passes.
+	// Defaults to 0, no timeout
+	Timeout time.Duration
+	// ItemTimeout bounds how long map, filter and flat map steps spend on a
+	// single attempt at an item. The item function's context has a deadline
+	// and the attempt fails with a *TimeoutError once it passes.
+	// Defaults to 0, no timeout
+	ItemTimeout time.Duration
+	// OnStart is called before the step starts its workers.
+	// Defaults to nil
+	OnStart StartFn
+	// OnFinish is called once the step's workers are done.
+	// Defaults to nil
+	OnFinish FinishFn
+	// Setup is called by each worker before it starts, such as to open a
+	// connection for the worker to use.
+	// Defaults to nil
+	Setup SetupFn
+	// Teardown is called by each worker once it's done, such as to close the
+	// connection returned by Setup.
+	// Defaults to nil
+	Teardown TeardownFn
+	// Autoscale adds and removes workers as the step's queue grows and
+	// shrinks, starting from WorkerCount. Ignored for fan out, partitioned
+	// and ordered steps.
+	// Defaults to nil, the number of workers is fixed
+	Autoscale *AutoscalePolicy
+	// The number of workers to spawn in go routines to handle this step,
+	// never more than MaxWorkerCount.
 	// Defaults to 1, set > 1 for concurrent processing
 	WorkerCount int
 	// fn is the actual func to execute
 	fn StepFn
+	// each is the per item func to execute instead of fn, if set
+	each itemFn
+	// nested is the pipeline this step runs, if any
+	nested *Pipeline
+	// stage is the stage running this step, if any
+	stage *Stage
 	// wg is a wait group to sync exit
 	wg *sync.WaitGroup
 	// f is a fan for multiplexing messages
 	f *fan
+	// metrics of the step's processing
+	metrics stepMetrics
+	// workers is the number of running workers
+	workers atomic.Int64
+	// busy is the number of workers handling an item in per item steps
+	busy atomic.Int64
+	// held is the number of items taken from the input but not yet passed on
+	held atomic.Int64
+	// buffers are the channels items queue in between the step's goroutines
+	buffers []chan interface{}
 }
 
 // NewStep creates a new step, defaults to worker step.
@@ -50,6 +137,13 @@ func NewWorkerStep(name string, workerCount int, step func(*Context, <-chan inte
 	return newStep(name, false, false, workerCount, step)
 }
 
+// NewOrderedWorkerStep creates a new worker based step that preserves input order.
+func NewOrderedWorkerStep(name string, workerCount int, step func(*Context, <-chan interface{}, chan interface{}) error) *Step {
+	s := newStep(name, false, false, workerCount, step)
+	s.Ordered = true
+	return s
+}
+
 // NewBufferedStep creates a new buffered step.
 func NewBufferedStep(name string, step func(*Context, <-chan interface{}, chan interface{}) error) *Step {
 	return newStep(name, true, false, 1, step)
@@ -72,53 +166,205 @@ func newStep(name string, buffered bool, fanout bool, workerCount int, fn func(*
 
 // Process executes this step.
 func (s *Step) Process(ctx *Context, in <-chan interface{}) chan interface{} {
+	s.reset()
 	c := &Context{s.Context(ctx), ctx.pipeline}
 	var out chan interface{}
 	if s.Buffered {
 		out = make(chan interface{}, MaxBufferSize)
+		s.buffers = append(s.buffers, out)
 	} else {
 		out = make(chan interface{})
 	}
-	if s.FanOut {
-		s.f = fanOut(in, s.WorkerCount)
+	if err := s.start(c); err != nil {
+		s.wg = &sync.WaitGroup{}
+		s.Go(func() error {
+			close(out)
+			return err
+		})
+		go discard(ctx, in)
+		return out
+	}
+	// stop is closed once the workers are done
+	stop := make(chan struct{})
+	input := in
+	if s.Timeout > 0 {
+		c = s.deadline(c, stop)
+	}
+	if limiters := s.limiters(c); len(limiters) > 0 || s.stage != nil {
+		in = s.intake(c, in, stop, limiters)
+	}
+	if s.WorkerCount > MaxWorkerCount {
+		s.WorkerCount = MaxWorkerCount
+	}
+	if s.Autoscale != nil && s.KeyFn == nil && !s.FanOut && !s.Ordered {
+		s.autoscale(c, in, out, stop)
+		s.Go(func() error {
+			return s.shutdown(c, input, out, stop, nil)
+		})
+		return out
+	}
+	ins := make([]<-chan interface{}, s.WorkerCount)
+	outs := make([]chan interface{}, s.WorkerCount)
+	var r *reorder
+	switch {
+	case s.KeyFn != nil:
+		s.f = fanPick(in, s.WorkerCount, 0, func(_ *fan, data interface{}) int {
+			return s.pick(c, data)
+		})
+		for i := range ins {
+			ins[i], outs[i] = s.f.outs[i], out
+		}
+	case s.FanOut:
+		switch s.Distribution {
+		case DistributionRoundRobin:
+			s.f = fanRoundRobin(in, s.WorkerCount)
+		case DistributionLeastLoaded:
+			s.f = fanLeastLoaded(in, s.WorkerCount)
+		default:
+			s.f = fanOut(in, s.WorkerCount)
+		}
+		for i := range ins {
+			ins[i], outs[i] = s.f.outs[i], out
+		}
+	case s.Ordered && s.WorkerCount > 1:
+		r = newReorder(c.Done(), in, out, s.WorkerCount, MaxReorderBufferSize, &s.held)
+		for i := range ins {
+			ins[i], outs[i] = r.ins[i], r.outs[i]
+		}
+	default:
+		for i := range ins {
+			ins[i], outs[i] = in, out
+		}
 	}
 	s.wg = &sync.WaitGroup{}
 	s.wg.Add(s.WorkerCount)
 	for i := 0; i < s.WorkerCount; i++ {
-		index := i
-		if s.FanOut {
-			s.Go(func() error {
-				defer s.wg.Done()
-				in := s.f.outs[index]
-				return s.fn(c, in, out)
-			})
-		} else {
-			s.Go(func() error {
-				defer s.wg.Done()
-				return s.fn(c, in, out)
-			})
+		in, out, wc := ins[i], outs[i], c
+		if r != nil {
+			wc = &Context{context.WithValue(c, itemDoneKey{}, r.dones[i]), c.pipeline}
 		}
+		s.workers.Add(1)
+		s.Go(func() (err error) {
+			defer s.wg.Done()
+			defer s.workers.Add(-1)
+			if r != nil {
+				// Workers have their own out channel when reordering
+				defer close(out)
+			}
+			defer s.recover(c, &err)
+			return s.work(wc, in, out)
+		})
 	}
 	s.Go(func() error {
-		s.wg.Wait()
-		// safe to kill fan
-		if s.f != nil {
-			s.f.Kill(nil)
-			s.f.Wait()
+		return s.shutdown(c, input, out, stop, r)
+	})
+	return out
+}
+
+// shutdown waits for the workers of the step to finish, then stops whatever
+// feeds them and closes the output. If the step failed the rest of its input
+// is discarded.
+func (s *Step) shutdown(ctx *Context, in <-chan interface{}, out chan interface{}, stop chan struct{}, r *reorder) error {
+	s.wg.Wait()
+	if err := s.Err(); err != nil && err != tomb.ErrStillAlive {
+		go discard(ctx, in)
+	}
+	close(stop)
+	// safe to kill fan
+	if s.f != nil {
+		s.f.Kill(nil)
+		s.f.Wait()
+	}
+	if r != nil {
+		r.Wait()
+	}
+	if out != nil {
+		close(out)
+	}
+	return s.finish(ctx)
+}
+
+// deadline returns a context that is done once the step's Timeout passes,
+// killing the step with a *TimeoutError unless it stopped first.
+func (s *Step) deadline(ctx *Context, stop <-chan struct{}) *Context {
+	timeout := &TimeoutError{Step: s.Name, Timeout: s.Timeout}
+	d, cancel := context.WithTimeoutCause(ctx, s.Timeout, timeout)
+	s.Go(func() error {
+		defer cancel()
+		select {
+		case <-stop:
+			// Workers that return on the deadline may stop first
+		case <-d.Done():
 		}
-		if out != nil {
-			close(out)
+		// The cause is only the timeout if it passed before ctx was done
+		if context.Cause(d) == timeout {
+			return timeout
 		}
 		return nil
 	})
+	return &Context{d, ctx.pipeline}
+}
+
+// intake passes items from in to the workers, holding off while the step is
+// paused and waiting on the limiters. Like a direct channel it keeps handing
+// items over after the context is done, until in is closed or the workers
+// are done.
+func (s *Step) intake(ctx *Context, in <-chan interface{}, stop <-chan struct{}, limiters []*RateLimiter) <-chan interface{} {
+	out := make(chan interface{})
+	s.Go(func() error {
+		defer close(out)
+		for {
+			// Stop pulling from the input while paused
+			s.wait(ctx)
+			select {
+			case <-stop:
+				return nil
+			case data, ok := <-in:
+				if !ok {
+					return nil
+				}
+				s.held.Add(1)
+				s.limit(ctx, limiters)
+				select {
+				case <-stop:
+					return nil
+				case out <- data:
+					s.held.Add(-1)
+				}
+			}
+		}
+	})
 	return out
 }
 
+// run executes the step's func for a single worker.
+func (s *Step) run(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+	if s.each != nil {
+		return s.forEach(ctx, in, out)
+	}
+	if s.nested != nil {
+		return s.runNested(ctx, in, out)
+	}
+	return s.fn(ctx, in, out)
+}
+
+// recover converts a panic into a *PanicError and reports it on the state
+// channel. It must be deferred.
+func (s *Step) recover(ctx *Context, err *error) {
+	if r := recover(); r != nil {
+		panicErr := &PanicError{s.Name, r, debug.Stack()}
+		ctx.report(&State{Name: s.Name, Status: StatusStepPanicked, Err: panicErr})
+		*err = panicErr
+	}
+}
+
 // Replicated duplicates this step for number of times requested.
 func (s *Step) Replicated(num int) []*Step {
 	steps := []*Step{}
 	for i := 0; i < num; i++ {
-		steps = append(steps, NewStep(s.Name, s.fn))
+		step := NewStep(s.Name, s.fn)
+		step.each = s.each
+		steps = append(steps, step)
 	}
 	return steps
 }
@@ -2,7 +2,9 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestProcess(t *testing.T) {
@@ -83,3 +85,72 @@ func TestWorkerCount(t *testing.T) {
 	cancel()
 	step.Wait()
 }
+
+func TestPanicRecovery(t *testing.T) {
+	step := NewStep("panicker", func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		panic("boom")
+	})
+	p := NewPipeline("panics")
+	in := make(chan interface{})
+	defer close(in)
+	for range step.Process(&Context{context.Background(), p}, in) {
+	}
+	err := step.Wait()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a panic error, found %v", err)
+	}
+	if panicErr.Step != "panicker" || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("unexpected panic error %+v", panicErr)
+	}
+	if state := <-p.State(); state.Status != StatusStepPanicked || state.Err != err {
+		t.Fatalf("expected panic state, found %+v", state)
+	}
+}
+
+func TestPanicRecoveryStateFull(t *testing.T) {
+	step := NewStep("panicker", func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		panic("boom")
+	})
+	p := NewPipeline("panics")
+	// No one has read the state channel yet
+	for len(p.stateCh) < cap(p.stateCh) {
+		p.stateCh <- &State{}
+	}
+	in := make(chan interface{})
+	defer close(in)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range step.Process(&Context{context.Background(), p}, in) {
+		}
+	}()
+	// Give the step time to panic while the channel is full
+	time.Sleep(20 * time.Millisecond)
+	for {
+		select {
+		case state := <-p.State():
+			if state.Status == StatusStepPanicked {
+				<-done
+				step.Wait()
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the panic to be reported")
+		}
+	}
+}
+
+func TestFanOutStepFinishes(t *testing.T) {
+	// Workers that return early shouldn't leave the fan waiting on input
+	step := NewFanOutStep("quitter", 3, func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		return nil
+	})
+	in := make(chan interface{})
+	defer close(in)
+	for range step.Process(&Context{context.Background(), nil}, in) {
+	}
+	if err := step.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStepTimeout(t *testing.T) {
+	step := waitingStep("hung")
+	step.Timeout = 20 * time.Millisecond
+	p := NewPipeline("timeout", NewSerialStage("stage", step))
+	_, err := p.Run(nil, 1)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, found %v", err)
+	}
+	if timeoutErr.Step != "hung" || timeoutErr.Item != nil {
+		t.Fatalf("expected the hung step to time out, found %+v", timeoutErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected timeout error to match context.DeadlineExceeded")
+	}
+}
+
+func TestStepTimeoutFinished(t *testing.T) {
+	step := NewMapStep("quick", 1, passMap)
+	step.Timeout = time.Second
+	p := NewPipeline("timeout", NewSerialStage("stage", step))
+	if _, err := p.Run(nil, 1, 2, 3); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+}
+
+func TestItemTimeout(t *testing.T) {
+	step := NewMapStep("slow", 1, func(ctx *Context, data interface{}) (interface{}, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("expected item context to have a deadline")
+		}
+		if data.(int) == 2 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return data, nil
+	})
+	step.ItemTimeout = 20 * time.Millisecond
+	step.ErrorMode = ErrorModeDeadLetter
+	p := NewPipeline("timeout", NewSerialStage("stage", step))
+	results, err := p.Run(nil, 1, 2, 3)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, found %d", len(results))
+	}
+	item := <-p.DeadLetters()
+	var timeoutErr *TimeoutError
+	if !errors.As(item.Err, &timeoutErr) || timeoutErr.Step != "slow" || timeoutErr.Item != 2 {
+		t.Fatalf("expected item 2 to time out, found %v", item.Err)
+	}
+}
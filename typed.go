@@ -0,0 +1,211 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TypedStepFn is the signature of a type safe step function.
+type TypedStepFn[In, Out any] func(*Context, <-chan In, chan Out) error
+
+// TypedStep is a step with compile time checked input and output types.
+// It wraps a regular step so it can be used anywhere a *Step is expected.
+type TypedStep[In, Out any] struct {
+	*Step
+}
+
+// NewTypedStep creates a new typed step, defaults to worker step.
+func NewTypedStep[In, Out any](name string, step TypedStepFn[In, Out]) *TypedStep[In, Out] {
+	return NewTypedWorkerStep(name, 1, step)
+}
+
+// NewTypedWorkerStep creates a new typed worker based step.
+func NewTypedWorkerStep[In, Out any](name string, workerCount int, step TypedStepFn[In, Out]) *TypedStep[In, Out] {
+	return &TypedStep[In, Out]{NewWorkerStep(name, workerCount, step.untyped(name))}
+}
+
+// AsTypedStep wraps an untyped step so it can be composed with typed steps.
+// Values flowing out of the step are type checked by whatever consumes them.
+func AsTypedStep[In, Out any](step *Step) *TypedStep[In, Out] {
+	return &TypedStep[In, Out]{step}
+}
+
+// untyped adapts a typed step function to a regular step function by
+// converting values on the way in and out of the function.
+func (fn TypedStepFn[In, Out]) untyped(name string) StepFn {
+	return func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		typedIn := make(chan In)
+		typedOut := make(chan Out)
+		done := make(chan struct{})
+		errCh := make(chan error, 1)
+		go func() {
+			defer close(typedIn)
+			for {
+				select {
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				case data, ok := <-in:
+					if !ok {
+						return
+					}
+					value, ok := assertType[In](data)
+					if !ok {
+						errCh <- typeError[In](name, data)
+						return
+					}
+					select {
+					case <-done:
+						return
+					case <-ctx.Done():
+						return
+					case typedIn <- value:
+					}
+				}
+			}
+		}()
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for data := range typedOut {
+				select {
+				case <-ctx.Done():
+				case out <- data:
+				}
+			}
+		}()
+		err := fn(ctx, typedIn, typedOut)
+		close(done)
+		close(typedOut)
+		<-forwarded
+		if err == nil {
+			select {
+			case err = <-errCh:
+			default:
+			}
+		}
+		return err
+	}
+}
+
+// TypedStage is a stage with compile time checked input and output types.
+type TypedStage[In, Out any] struct {
+	*Stage
+}
+
+// NewTypedStage creates a new serial stage starting with the provided step.
+// Use ThenStep to append more steps to the stage.
+func NewTypedStage[In, Out any](name string, step *TypedStep[In, Out]) *TypedStage[In, Out] {
+	return &TypedStage[In, Out]{NewSerialStage(name, step.Step)}
+}
+
+// NewTypedConcurrentStage creates a new stage for given steps that will run concurrently.
+func NewTypedConcurrentStage[In, Out any](name string, steps ...*TypedStep[In, Out]) *TypedStage[In, Out] {
+	s := NewConcurrentStage(name)
+	for _, step := range steps {
+		s.AddStep(step.Step)
+	}
+	return &TypedStage[In, Out]{s}
+}
+
+// ThenStep appends a step to a serial typed stage. The step's input type must
+// match the stage's current output type.
+func ThenStep[In, Mid, Out any](stage *TypedStage[In, Mid], step *TypedStep[Mid, Out]) *TypedStage[In, Out] {
+	stage.AddStep(step.Step)
+	return &TypedStage[In, Out]{stage.Stage}
+}
+
+// TypedPipeline is a pipeline with compile time checked input and output types.
+type TypedPipeline[In, Out any] struct {
+	*Pipeline
+}
+
+// NewTypedPipeline creates a new pipeline starting with the provided stage.
+// Use ThenStage to append more stages to the pipeline.
+func NewTypedPipeline[In, Out any](name string, stage *TypedStage[In, Out]) *TypedPipeline[In, Out] {
+	return &TypedPipeline[In, Out]{NewPipeline(name, stage.Stage)}
+}
+
+// ThenStage appends a stage to a typed pipeline. The stage's input type must
+// match the pipeline's current output type.
+func ThenStage[In, Mid, Out any](p *TypedPipeline[In, Mid], stage *TypedStage[Mid, Out]) *TypedPipeline[In, Out] {
+	p.AddStage(stage.Stage)
+	return &TypedPipeline[In, Out]{p.Pipeline}
+}
+
+// Process executes the pipeline with typed input and output channels.
+func (p *TypedPipeline[In, Out]) Process(ctx context.Context, in <-chan In) chan Out {
+	untypedIn := make(chan interface{})
+	// Start the run before watching it so the pump sees the run's lifecycle,
+	// which may be on a clone if the pipeline is still running
+	r := p.acquire()
+	untypedOut := r.process(ctx, untypedIn)
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		wg.Wait()
+		r.release()
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(untypedIn)
+		for {
+			select {
+			case <-r.Dying():
+				return
+			case data, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-r.Dying():
+					return
+				case untypedIn <- data:
+				}
+			}
+		}
+	}()
+	out := make(chan Out)
+	go func() {
+		defer wg.Done()
+		defer close(out)
+		for data := range untypedOut {
+			value, ok := assertType[Out](data)
+			if !ok {
+				r.fail(&StepError{Step: r.Name, Time: time.Now(), Err: typeError[Out](r.Name, data)})
+				continue
+			}
+			select {
+			case out <- value:
+			case <-r.Dying():
+				// The output of a run that finished is still delivered, the
+				// output of one that failed or was stopped is dropped
+				if r.Err() != nil {
+					continue
+				}
+				out <- value
+			}
+		}
+	}()
+	return out
+}
+
+// assertType converts a value to T, allowing nil for types that can be nil.
+func assertType[T any](data interface{}) (T, bool) {
+	value, ok := data.(T)
+	if !ok && data == nil {
+		switch reflect.TypeOf(&value).Elem().Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+			ok = true
+		}
+	}
+	return value, ok
+}
+
+func typeError[T any](name string, data interface{}) error {
+	return fmt.Errorf("pipeline: %q expected %s, received %T", name, reflect.TypeOf((*T)(nil)).Elem(), data)
+}
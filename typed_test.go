@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestTypedStep(t *testing.T) {
+	step := NewTypedStep("doubler", func(ctx *Context, in <-chan int, out chan int) error {
+		for n := range in {
+			out <- n * 2
+		}
+		return nil
+	})
+	in := make(chan interface{})
+	go func() {
+		for i := 1; i <= 3; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	out := step.Process(&Context{context.Background(), nil}, in)
+	total := 0
+	for n := range out {
+		total += n.(int)
+	}
+	if total != 12 {
+		t.Fatalf("typed step should have doubled values to total 12, found %d", total)
+	}
+	if err := step.Wait(); err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+}
+
+func TestTypedStepMismatch(t *testing.T) {
+	step := NewTypedStep("doubler", func(ctx *Context, in <-chan int, out chan int) error {
+		for n := range in {
+			out <- n * 2
+		}
+		return nil
+	})
+	in := make(chan interface{}, 1)
+	in <- "two"
+	close(in)
+	for range step.Process(&Context{context.Background(), nil}, in) {
+	}
+	if err := step.Wait(); err == nil {
+		t.Fatal("expected type mismatch error")
+	}
+}
+
+func TestTypedPipeline(t *testing.T) {
+	double := NewTypedStep("double", func(ctx *Context, in <-chan int, out chan int) error {
+		for n := range in {
+			out <- n * 2
+		}
+		return nil
+	})
+	format := NewTypedStep("format", func(ctx *Context, in <-chan int, out chan string) error {
+		for n := range in {
+			out <- strconv.Itoa(n)
+		}
+		return nil
+	})
+	// Untyped steps interoperate by asserting their types
+	suffix := AsTypedStep[string, string](NewStep("suffix", func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		for s := range in {
+			out <- s.(string) + "!"
+		}
+		return nil
+	}))
+	stage := ThenStep(NewTypedStage("numbers", double), format)
+	p := ThenStage(NewTypedPipeline("typed", stage), NewTypedStage("strings", suffix))
+
+	in := make(chan int)
+	go func() {
+		for i := 1; i <= 3; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	results := []string{}
+	for s := range p.Process(nil, in) {
+		results = append(results, s)
+	}
+	expected := []string{"2!", "4!", "6!"}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, found %d", len(expected), len(results))
+	}
+	for i, s := range results {
+		if s != expected[i] {
+			t.Fatalf("expected %s, found %s", expected[i], s)
+		}
+	}
+	p.Wait()
+}
+
+func TestTypedPipelineConcurrently(t *testing.T) {
+	double := NewTypedStep("double", func(ctx *Context, in <-chan int, out chan int) error {
+		for n := range in {
+			out <- n * 2
+		}
+		return nil
+	})
+	p := NewTypedPipeline("typed", NewTypedStage("numbers", double))
+	// The first run finishes while the second is still taking input
+	short := make(chan int)
+	close(short)
+	long := make(chan int)
+	first := p.Process(nil, short)
+	second := p.Process(nil, long)
+	for range first {
+	}
+	p.Wait()
+	go func() {
+		for i := 0; i < 200; i++ {
+			long <- i
+		}
+		close(long)
+	}()
+	count := 0
+	for range second {
+		count++
+	}
+	if count != 200 {
+		t.Fatalf("expected all 200 items of the concurrent run, found %d", count)
+	}
+}
+
+func TestAssertType(t *testing.T) {
+	if _, ok := assertType[*user](nil); !ok {
+		t.Error("expected nil to be a valid pointer value")
+	}
+	if _, ok := assertType[int](nil); ok {
+		t.Error("expected nil to be an invalid int value")
+	}
+	if v, ok := assertType[int](5); !ok || v != 5 {
+		t.Errorf("expected 5, found %d", v)
+	}
+}
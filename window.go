@@ -0,0 +1,352 @@
+package pipeline
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// WindowKind is the way items are grouped into windows.
+type WindowKind int
+
+const (
+	// WindowTumbling groups items into fixed size windows that don't overlap
+	WindowTumbling WindowKind = iota
+	// WindowSliding groups items into fixed size windows that start every
+	// slide and may overlap
+	WindowSliding
+	// WindowSession groups items into windows that close after a gap with no items
+	WindowSession
+)
+
+// ErrLateItem is the error for items that arrive after their windows have closed.
+var ErrLateItem = errors.New("pipeline: item arrived after its window closed")
+
+// TimeFn extracts the event time from an item.
+type TimeFn func(interface{}) time.Time
+
+// ReduceFn combines the items of a window into a single result.
+type ReduceFn func(*Context, *Window) (interface{}, error)
+
+// Window is a group of items for a span of time.
+type Window struct {
+	// Start of the window, inclusive
+	Start time.Time
+	// End of the window, exclusive
+	End time.Time
+	// Items in the window in the order they arrived
+	Items []interface{}
+}
+
+// WindowPolicy configures how a window step groups items.
+type WindowPolicy struct {
+	// Kind of windows to create.
+	// Defaults to WindowTumbling
+	Kind WindowKind
+	// Size is the length of tumbling and sliding windows.
+	Size time.Duration
+	// Slide is how often a new sliding window starts.
+	Slide time.Duration
+	// Gap is how long a session window stays open without items.
+	Gap time.Duration
+	// EventTime extracts the time of each item so windows follow event time.
+	// Defaults to nil, windows follow processing time
+	EventTime TimeFn
+	// MaxDelay is how far the watermark trails the latest event time seen,
+	// allowing for items that arrive out of order. Windows fire once the
+	// watermark passes their end.
+	// Defaults to 0
+	MaxDelay time.Duration
+	// AllowedLateness keeps windows open after they fire so that late items
+	// update them, firing the window again. Items arriving after that are
+	// dropped, or sent to the dead letter channel with ErrLateItem when using
+	// ErrorModeDeadLetter.
+	// Defaults to 0
+	AllowedLateness time.Duration
+}
+
+// TumblingWindow returns a policy for tumbling windows of the given size.
+func TumblingWindow(size time.Duration) *WindowPolicy {
+	return &WindowPolicy{Kind: WindowTumbling, Size: size}
+}
+
+// SlidingWindow returns a policy for windows of the given size starting every slide.
+func SlidingWindow(size, slide time.Duration) *WindowPolicy {
+	return &WindowPolicy{Kind: WindowSliding, Size: size, Slide: slide}
+}
+
+// SessionWindow returns a policy for windows that close after the given gap.
+func SessionWindow(gap time.Duration) *WindowPolicy {
+	return &WindowPolicy{Kind: WindowSession, Gap: gap}
+}
+
+// NewWindowStep creates a new step that groups items into windows and emits
+// the result of reduce for each window. Remaining windows are fired when the
+// input is closed.
+func NewWindowStep(name string, window *WindowPolicy, reduce ReduceFn) *Step {
+	s := newStep(name, false, false, 1, nil)
+	s.fn = func(ctx *Context, in <-chan interface{}, out chan interface{}) error {
+		if err := window.validate(); err != nil {
+			return err
+		}
+		w := &windower{step: s, policy: window, reduce: reduce, out: out}
+		defer w.stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-w.timeout:
+				if err := w.advance(ctx, time.Now()); err != nil {
+					return err
+				}
+			case data, ok := <-in:
+				if !ok {
+					return w.flush(ctx)
+				}
+				if err := w.add(ctx, data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s
+}
+
+func (p *WindowPolicy) validate() error {
+	switch p.Kind {
+	case WindowTumbling:
+		if p.Size <= 0 {
+			return errors.New("pipeline: tumbling window size must be positive")
+		}
+	case WindowSliding:
+		if p.Size <= 0 || p.Slide <= 0 {
+			return errors.New("pipeline: sliding window size and slide must be positive")
+		}
+	case WindowSession:
+		if p.Gap <= 0 {
+			return errors.New("pipeline: session window gap must be positive")
+		}
+	}
+	return nil
+}
+
+// assign returns the windows an item with the given time belongs to.
+func (p *WindowPolicy) assign(t time.Time) []*Window {
+	switch p.Kind {
+	case WindowSliding:
+		windows := []*Window{}
+		for start := t.Truncate(p.Slide); start.Add(p.Size).After(t); start = start.Add(-p.Slide) {
+			windows = append(windows, &Window{Start: start, End: start.Add(p.Size)})
+		}
+		return windows
+	case WindowSession:
+		return []*Window{{Start: t, End: t.Add(p.Gap)}}
+	default:
+		start := t.Truncate(p.Size)
+		return []*Window{{Start: start, End: start.Add(p.Size)}}
+	}
+}
+
+// pane is a window being collected.
+type pane struct {
+	*Window
+	// fired indicates the window's result has been emitted
+	fired bool
+	// dirty indicates the window has changed since it fired
+	dirty bool
+	// emitted is the number of items the window held when it last fired
+	emitted int
+}
+
+// windower holds the open windows of a window step.
+type windower struct {
+	step      *Step
+	policy    *WindowPolicy
+	reduce    ReduceFn
+	out       chan interface{}
+	panes     []*pane
+	held      int
+	latest    time.Time
+	watermark time.Time
+	timer     *time.Timer
+	timeout   <-chan time.Time
+}
+
+// add assigns an item to its windows and fires any that are complete.
+func (w *windower) add(ctx *Context, data interface{}) error {
+	now := time.Now()
+	t := now
+	if w.policy.EventTime != nil {
+		t = w.policy.EventTime(data)
+	} else {
+		w.watermark = now
+	}
+	added := false
+	for _, window := range w.policy.assign(t) {
+		if w.closed(window) {
+			continue
+		}
+		w.insert(window, data)
+		added = true
+	}
+	if !added && w.step.ErrorMode == ErrorModeDeadLetter {
+		if err := ctx.deadLetter(&FailedItem{w.step.Name, data, ErrLateItem, 1}); err != nil {
+			return err
+		}
+	}
+	if w.policy.EventTime == nil {
+		return w.advance(ctx, now)
+	}
+	if t.After(w.latest) {
+		w.latest = t
+	}
+	return w.advance(ctx, w.latest.Add(-w.policy.MaxDelay))
+}
+
+// closed reports whether a window no longer accepts items.
+func (w *windower) closed(window *Window) bool {
+	if w.watermark.IsZero() {
+		return false
+	}
+	return !w.watermark.Before(window.End.Add(w.policy.AllowedLateness))
+}
+
+// insert adds an item to the matching pane, merging session windows that
+// now overlap.
+func (w *windower) insert(window *Window, data interface{}) {
+	if w.policy.Kind != WindowSession {
+		for _, p := range w.panes {
+			if p.Start.Equal(window.Start) && p.End.Equal(window.End) {
+				p.Items = append(p.Items, data)
+				p.dirty = p.fired
+				return
+			}
+		}
+		w.panes = append(w.panes, &pane{Window: &Window{Start: window.Start, End: window.End, Items: []interface{}{data}}})
+		return
+	}
+	merged := &pane{Window: &Window{Start: window.Start, End: window.End}}
+	panes := w.panes[:0]
+	for _, p := range w.panes {
+		if p.Start.Before(merged.End) && merged.Start.Before(p.End) {
+			if p.Start.Before(merged.Start) {
+				merged.Start = p.Start
+			}
+			if p.End.After(merged.End) {
+				merged.End = p.End
+			}
+			merged.Items = append(merged.Items, p.Items...)
+			merged.fired = merged.fired || p.fired
+			merged.emitted += p.emitted
+			continue
+		}
+		panes = append(panes, p)
+	}
+	merged.Items = append(merged.Items, data)
+	merged.dirty = merged.fired
+	w.panes = append(panes, merged)
+}
+
+// advance moves the watermark forward, firing windows that have ended and
+// discarding those past their allowed lateness.
+func (w *windower) advance(ctx *Context, watermark time.Time) error {
+	if watermark.After(w.watermark) {
+		w.watermark = watermark
+	}
+	w.sort()
+	panes := w.panes[:0]
+	for _, p := range w.panes {
+		if !w.watermark.Before(p.End) && (!p.fired || p.dirty) {
+			if err := w.fire(ctx, p); err != nil {
+				return err
+			}
+		}
+		if !w.closed(p.Window) {
+			panes = append(panes, p)
+		}
+	}
+	w.panes = panes
+	w.schedule()
+	w.track(ctx)
+	return nil
+}
+
+// flush fires every remaining window at the end of the input.
+func (w *windower) flush(ctx *Context) error {
+	w.sort()
+	for _, p := range w.panes {
+		if !p.fired || p.dirty {
+			if err := w.fire(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+	w.panes = nil
+	w.track(ctx)
+	return nil
+}
+
+// track counts the items waiting for their windows to fire as held by the
+// step. Items in several sliding windows count once for each.
+func (w *windower) track(ctx *Context) {
+	held := 0
+	for _, p := range w.panes {
+		held += len(p.Items) - p.emitted
+	}
+	ctx.hold(held - w.held)
+	w.held = held
+}
+
+// sort orders the panes by when they end so results are emitted in order.
+func (w *windower) sort() {
+	sort.SliceStable(w.panes, func(i, j int) bool {
+		if w.panes[i].End.Equal(w.panes[j].End) {
+			return w.panes[i].Start.Before(w.panes[j].Start)
+		}
+		return w.panes[i].End.Before(w.panes[j].End)
+	})
+}
+
+// fire emits the reduced result of a window.
+func (w *windower) fire(ctx *Context, p *pane) error {
+	p.fired, p.dirty, p.emitted = true, false, len(p.Items)
+	window := &Window{Start: p.Start, End: p.End, Items: append([]interface{}{}, p.Items...)}
+	result, err := w.reduce(ctx, window)
+	if err != nil {
+		if w.step.ErrorMode != ErrorModeDeadLetter {
+			return err
+		}
+		return ctx.deadLetter(&FailedItem{w.step.Name, window, err, 1})
+	}
+	select {
+	case <-ctx.Done():
+	case w.out <- result:
+	}
+	return nil
+}
+
+// schedule sets a timer for the next window to end when using processing time.
+func (w *windower) schedule() {
+	w.stop()
+	if w.policy.EventTime != nil {
+		return
+	}
+	var next time.Time
+	for _, p := range w.panes {
+		if !p.fired && (next.IsZero() || p.End.Before(next)) {
+			next = p.End
+		}
+	}
+	if !next.IsZero() {
+		w.timer = time.NewTimer(time.Until(next))
+		w.timeout = w.timer.C
+	}
+}
+
+func (w *windower) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+		w.timeout = nil
+	}
+}
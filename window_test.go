@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// atSeconds uses items as their event time in seconds.
+func atSeconds(data interface{}) time.Time {
+	return time.Unix(int64(data.(int)), 0)
+}
+
+func sumWindow(ctx *Context, w *Window) (interface{}, error) {
+	sum := 0
+	for _, v := range w.Items {
+		sum += v.(int)
+	}
+	return sum, nil
+}
+
+func countWindow(ctx *Context, w *Window) (interface{}, error) {
+	return len(w.Items), nil
+}
+
+func expectInts(t *testing.T, results []interface{}, expected ...int) {
+	t.Helper()
+	if len(results) != len(expected) {
+		t.Fatalf("expected %v, found %v", expected, results)
+	}
+	for i, v := range results {
+		if v.(int) != expected[i] {
+			t.Fatalf("expected %v, found %v", expected, results)
+		}
+	}
+}
+
+func TestTumblingWindow(t *testing.T) {
+	window := TumblingWindow(10 * time.Second)
+	window.EventTime = atSeconds
+	step := NewWindowStep("tumbling", window, sumWindow)
+	expectInts(t, processItems(step, 1, 2, 11, 12, 25), 3, 23, 25)
+	step.Wait()
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := SlidingWindow(10*time.Second, 5*time.Second)
+	window.EventTime = atSeconds
+	step := NewWindowStep("sliding", window, countWindow)
+	expectInts(t, processItems(step, 1, 6, 11), 1, 2, 2, 1)
+	step.Wait()
+}
+
+func TestSessionWindow(t *testing.T) {
+	window := SessionWindow(5 * time.Second)
+	window.EventTime = atSeconds
+	step := NewWindowStep("session", window, sumWindow)
+	expectInts(t, processItems(step, 1, 2, 10, 12, 14, 30), 3, 36, 30)
+	step.Wait()
+}
+
+func TestWindowMaxDelay(t *testing.T) {
+	window := TumblingWindow(10 * time.Second)
+	window.EventTime = atSeconds
+	window.MaxDelay = 5 * time.Second
+	step := NewWindowStep("delayed", window, sumWindow)
+	expectInts(t, processItems(step, 1, 12, 3, 16), 4, 28)
+	step.Wait()
+}
+
+func TestWindowAllowedLateness(t *testing.T) {
+	window := TumblingWindow(10 * time.Second)
+	window.EventTime = atSeconds
+	window.AllowedLateness = 10 * time.Second
+	step := NewWindowStep("late", window, sumWindow)
+	step.ErrorMode = ErrorModeDeadLetter
+	p := NewPipeline("windows", NewSerialStage("windows", step))
+	results, err := p.Run(nil, 1, 12, 3, 25, 5)
+	if err != nil {
+		t.Fatalf("expected no error, found %v", err)
+	}
+	// The first window fires again for the late item, then closes
+	expectInts(t, results, 1, 4, 12, 25)
+	select {
+	case item := <-p.DeadLetters():
+		if item.Input.(int) != 5 || !errors.Is(item.Err, ErrLateItem) {
+			t.Fatalf("expected item 5 to be late, found %v: %v", item.Input, item.Err)
+		}
+	default:
+		t.Fatal("expected late item to be dead lettered")
+	}
+}
+
+func TestProcessingTimeWindow(t *testing.T) {
+	step := NewWindowStep("processing", TumblingWindow(20*time.Millisecond), countWindow)
+	in := make(chan interface{})
+	out := step.Process(&Context{context.Background(), nil}, in)
+	in <- 1
+	select {
+	case data := <-out:
+		if data.(int) < 1 {
+			t.Fatalf("expected window with items, found %d", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected window to fire without more input")
+	}
+	close(in)
+	for range out {
+	}
+	step.Wait()
+}
+
+func TestWindowInvalidPolicy(t *testing.T) {
+	step := NewWindowStep("invalid", SessionWindow(0), countWindow)
+	processItems(step, 1)
+	if err := step.Wait(); err == nil {
+		t.Fatal("expected invalid window policy error")
+	}
+}